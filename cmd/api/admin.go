@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/mailer"
+)
+
+var (
+	errInvalidLocale        = errors.New("Invalid locale")
+	errInvalidPreviewFormat = errors.New("Invalid format, expected json, html or text")
+)
+
+// mailPreviewData fills the variables of every email template in the locale
+func mailPreviewData(locale string) map[string]any {
+	return map[string]any{
+		"Username":      "jane",
+		"ActivationURL": "https://example.com/confirm/00000000-0000-0000-0000-000000000000",
+		"ResetURL":      "https://example.com/reset-password/00000000-0000-0000-0000-000000000000",
+		"ConfirmURL":    "https://example.com/confirm-email/00000000-0000-0000-0000-000000000000",
+		"NewEmail":      "jane.doe@example.com",
+		"ExpiresIn":     i18n.Duration(locale, time.Hour),
+	}
+}
+
+// listMailTemplatesHandler godoc
+//
+//	@Summary		Lists the email templates
+//	@Description	Lists the names of the email templates that can be previewed
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		string
+//	@Failure		403	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/mail/preview [get]
+func (app *application) listMailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.jsonResponse(w, http.StatusOK, mailer.Templates()); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// previewMailHandler godoc
+//
+//	@Summary		Previews an email template
+//	@Description	Renders an email template with sample data, in English unless a locale is given. format=html returns the HTML part as a page, format=text the plain-text part.
+//	@Tags			admin
+//	@Produce		json
+//	@Produce		html
+//	@Param			template	path		string	true	"Template name, e.g. user_verification.tmpl"
+//	@Param			format		query		string	false	"json (default), html or text"
+//	@Param			locale		query		string	false	"Locale, en by default"
+//	@Success		200			{object}	mailer.Rendered
+//	@Failure		400			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/mail/preview/{template} [get]
+func (app *application) previewMailHandler(w http.ResponseWriter, r *http.Request) {
+	locale := r.URL.Query().Get("locale")
+	if locale == "" {
+		locale = i18n.Default
+	}
+	if !i18n.IsSupported(locale) {
+		app.badRequestResponse(w, r, errInvalidLocale)
+		return
+	}
+
+	rendered, err := mailer.Render(chi.URLParam(r, "template"), locale, mailPreviewData(locale))
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrUnknownTemplate):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		if err := app.jsonResponse(w, http.StatusOK, rendered); err != nil {
+			app.internalServerResponse(w, r, err)
+		}
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rendered.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(rendered.Text))
+	default:
+		app.badRequestResponse(w, r, errInvalidPreviewFormat)
+	}
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -10,24 +17,103 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/mustaphalimar/go-social/docs"
 	"github.com/mustaphalimar/go-social/internal/auth"
+	"github.com/mustaphalimar/go-social/internal/health"
+	"github.com/mustaphalimar/go-social/internal/lifecycle"
 	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/ratelimiter"
 	"github.com/mustaphalimar/go-social/internal/store"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
 )
 
 type application struct {
-	config        config
-	store         store.Storage
-	logger        *zap.SugaredLogger
+	config config
+	db     *sql.DB
+	store  store.Storage
+	logger *zap.SugaredLogger
+	// accessLogger writes the access log, which isn't sampled
+	accessLogger  *zap.SugaredLogger
 	mailer        mailer.Client
 	authenticator auth.Authenticator
+	rateLimiter   ratelimiter.Limiter
+	lifecycle     *lifecycle.Manager
+	healthChecks  *health.Cache
+	metrics       *metrics
+	// trustedProxies are the peers whose X-Forwarded-For and X-Real-IP headers are believed
+	trustedProxies []netip.Prefix
 }
 
 type mailConfig struct {
-	exp       time.Duration
-	apiKey    string
-	fromEmail string
+	exp      time.Duration
+	resetExp time.Duration
+	emailExp time.Duration
+	// resendCooldown is how long an invitation must be kept before another activation email can be sent
+	resendCooldown time.Duration
+	fromEmail      string
+	// backend is one of sendgrid, smtp, file or log
+	backend string
+	apiKey  string
+	smtp    smtpConfig
+	// dir is where the file backend writes its .eml files
+	dir string
+}
+
+type smtpConfig struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+type outboxConfig struct {
+	workers      int
+	pollInterval time.Duration
+	// lease is how long a claimed job stays hidden from the other workers
+	lease       time.Duration
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	// retention is how long sent and dead emails are kept before the janitor purges them
+	retention time.Duration
+}
+
+type janitorConfig struct {
+	interval time.Duration
+	// unactivatedGrace is how long an account can stay unactivated before it is deleted
+	unactivatedGrace time.Duration
+}
+
+type rateLimitConfig struct {
+	enabled bool
+	// backend is memory, or postgres to share the counts between replicas
+	backend string
+	// auth limits the unauthenticated endpoints, by IP
+	auth ratelimiter.Limit
+	// client limits the authenticated endpoints by IP before the token is checked, so that requests with
+	// made up tokens are throttled too
+	client ratelimiter.Limit
+	// api limits everything else, by user
+	api ratelimiter.Limit
+}
+
+type shutdownConfig struct {
+	// delay is how long the server keeps serving once draining, for load balancers to notice it isn't ready
+	delay time.Duration
+	// timeout is how long in-flight requests and background tasks are given to finish
+	timeout time.Duration
+}
+
+type tracingConfig struct {
+	// exporter is otlp, stdout or none
+	exporter    string
+	serviceName string
+}
+
+type logConfig struct {
+	// level is debug, info, warn or error
+	level            string
+	sampleInitial    int
+	sampleThereafter int
 }
 
 type dbConfig struct {
@@ -45,6 +131,14 @@ type config struct {
 	mail      mailConfig
 	clientURL string
 	auth      authConfig
+	janitor   janitorConfig
+	outbox    outboxConfig
+	rateLimit rateLimitConfig
+	shutdown  shutdownConfig
+	tracing   tracingConfig
+	log       logConfig
+	// trustedProxies are the addresses or CIDRs of the proxies in front of the API
+	trustedProxies []string
 }
 
 type authConfig struct {
@@ -53,9 +147,13 @@ type authConfig struct {
 }
 
 type jwtConfig struct {
-	secret string
-	exp    time.Duration
-	iss    string
+	secret     string
+	exp        time.Duration
+	refreshExp time.Duration
+	iss        string
+	// signingKeyFile switches from HMAC to RS256/EdDSA when set
+	signingKeyFile       string
+	verificationKeyFiles []string
 }
 
 type basicConfig struct {
@@ -69,19 +167,32 @@ func (app *application) mount() http.Handler {
 	// middlewares
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Accept-Language", "Authorization", "Content-Type", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Link", "Content-Language", "Retry-After", "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Logger)
+	// outside of Recoverer, to count the panics as the 500 they end up as
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.realIP)
+	r.Use(app.metrics.middleware)
+	r.Use(app.tracingMiddleware)
+	r.Use(app.requestLogger)
+	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(app.localeMiddleware)
+
+	authLimit := app.rateLimit("auth", app.config.rateLimit.auth)
+	clientLimit := app.rateLimit("client", app.config.rateLimit.client)
+	apiLimit := app.rateLimit("api", app.config.rateLimit.api)
 
 	// routers
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+	r.Get("/livez", app.livenessHandler)
+	r.Get("/readyz", app.readinessHandler)
+	r.With(app.BasicAuthMiddleware()).Get("/metrics", app.metrics.handler().ServeHTTP)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.With(app.BasicAuthMiddleware()).Get("/health", app.healthCheckHandler)
 
@@ -93,45 +204,100 @@ func (app *application) mount() http.Handler {
 
 		// v1/posts
 		r.Route("/posts", func(r chi.Router) {
-			r.Use(app.AuthTokenMiddleware)
+			r.Use(clientLimit, app.AuthTokenMiddleware, apiLimit)
 			// POST v1/posts
-			r.Post("/", app.createPostHandler)
+			r.With(app.requireScope(scopePostsWrite)).Post("/", app.createPostHandler)
 
 			// v1/posts/someId
 			r.Route("/{postId}", func(r chi.Router) {
 				r.Use(app.postsContextMiddleware) // fetches the post and add it to the context of the request
 
 				// POST v1/posts/someId
-				r.Get("/", app.getPostHandler)
+				r.With(app.requireScope(scopePostsRead)).Get("/", app.getPostHandler)
 				// PATCH v1/posts/someId
-				r.Patch("/", app.checkPostsOwnership("moderator", app.updatePostHandler))
+				r.With(app.requireScope(scopePostsWrite)).Patch("/", app.checkPostsOwnership("moderator", app.updatePostHandler))
 				// DELETE v1/posts/someId
-				r.Delete("/", app.checkPostsOwnership("admin", app.deletePostHandler))
+				r.With(app.requireScope(scopePostsWrite)).Delete("/", app.checkPostsOwnership("admin", app.deletePostHandler))
+
+				// v1/posts/someId/comments
+				r.Route("/comments", func(r chi.Router) {
+					r.With(app.requireScope(scopeCommentsWrite)).Post("/", app.createCommentHandler)
+					r.With(app.requireScope(scopeCommentsRead)).Get("/", app.getCommentsHandler)
+
+					r.Route("/{commentId}", func(r chi.Router) {
+						r.Use(app.commentsContextMiddleware)
+
+						r.With(app.requireScope(scopeCommentsRead)).Get("/replies", app.getCommentRepliesHandler)
+						r.With(app.requireScope(scopeCommentsWrite)).Patch("/", app.checkCommentsOwnership("moderator", app.updateCommentHandler))
+						r.With(app.requireScope(scopeCommentsWrite)).Delete("/", app.checkCommentsOwnership("admin", app.deleteCommentHandler))
+					})
+				})
 			})
 
 		})
 
 		r.Route("/users", func(r chi.Router) {
-			r.Put("/activate/{token}", app.activateUserHandler)
+			r.With(authLimit).Put("/activate/{token}", app.activateUserHandler)
+			r.With(authLimit).Put("/email/{token}", app.confirmEmailHandler)
+
+			// v1/users/me
+			r.Route("/me", func(r chi.Router) {
+				r.Use(clientLimit, app.AuthTokenMiddleware, apiLimit)
+
+				r.With(app.requireScope(scopeUsersRead)).Get("/", app.getMeHandler)
+				r.With(app.requireScope(scopeUsersWrite)).Patch("/", app.updateMeHandler)
+				r.With(app.sessionOnly).Post("/email", app.changeEmailHandler)
+				r.With(app.sessionOnly).Get("/logins", app.getLoginsHandler)
+
+				r.Route("/2fa", func(r chi.Router) {
+					r.Use(app.sessionOnly)
+					r.Post("/", app.enableTwoFactorHandler)
+					r.Post("/confirm", app.confirmTwoFactorHandler)
+					r.Post("/disable", app.disableTwoFactorHandler)
+				})
+
+				// personal access tokens can't mint or revoke other tokens
+				r.Route("/tokens", func(r chi.Router) {
+					r.Use(app.sessionOnly)
+					r.Post("/", app.createPersonalTokenHandler)
+					r.Get("/", app.listPersonalTokensHandler)
+					r.Delete("/{tokenId}", app.deletePersonalTokenHandler)
+				})
+			})
 
 			r.Route("/{userId}", func(r chi.Router) {
-				r.Use(app.AuthTokenMiddleware)
-				r.Get("/", app.getUserHandler)
+				r.Use(clientLimit, app.AuthTokenMiddleware, apiLimit)
+				r.With(app.requireScope(scopeUsersRead)).Get("/", app.getUserHandler)
 
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+				r.With(app.requireScope(scopeUsersWrite)).Put("/follow", app.followUserHandler)
+				r.With(app.requireScope(scopeUsersWrite)).Put("/unfollow", app.unfollowUserHandler)
 			})
 
 			r.Group(func(r chi.Router) {
-				r.Use(app.AuthTokenMiddleware)
-				r.Get("/feed", app.getUserFeedHandler)
+				r.Use(clientLimit, app.AuthTokenMiddleware, apiLimit)
+				r.With(app.requireScope(scopeFeedRead)).Get("/feed", app.getUserFeedHandler)
 			})
 		})
 
+		r.With(clientLimit, app.AuthTokenMiddleware, apiLimit, app.requireScope(scopeSearchRead)).Get("/search", app.searchHandler)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(clientLimit, app.AuthTokenMiddleware, apiLimit, app.sessionOnly, app.requireRole("admin"))
+			r.Get("/mail/preview", app.listMailTemplatesHandler)
+			r.Get("/mail/preview/{template}", app.previewMailHandler)
+		})
+
 		// auth routes
 		r.Route("/auth", func(r chi.Router) {
+			r.Use(authLimit)
 			r.Post("/register", app.registerUserHandler)
+			r.Post("/activation/resend", app.resendActivationHandler)
 			r.Post("/token", app.createTokenHandler)
+			r.Post("/token/2fa", app.verifyTwoFactorHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.Post("/password/forgot", app.forgotPasswordHandler)
+			r.Post("/password/reset", app.resetPasswordHandler)
+			r.With(app.AuthTokenMiddleware, app.sessionOnly).Post("/logout", app.logoutHandler)
 		})
 
 	})
@@ -153,7 +319,39 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Minute,
 	}
 
+	shutdown := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-quit
+
+		app.logger.Infow("Server is shutting down", "signal", sig.String(), "timeout", app.config.shutdown.timeout)
+		app.lifecycle.Drain()
+		time.Sleep(app.config.shutdown.delay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
+		defer cancel()
+
+		// requests first, as they may enqueue work for the background tasks
+		if err := srv.Shutdown(ctx); err != nil {
+			shutdown <- err
+			return
+		}
+		shutdown <- app.lifecycle.Stop(ctx)
+	}()
+
 	app.logger.Infow("Server has started", "addr", app.config.addr, "env", app.config.env)
 
-	return srv.ListenAndServe()
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	if err := <-shutdown; err != nil {
+		return err
+	}
+
+	app.logger.Infow("Server has stopped", "addr", app.config.addr)
+
+	return nil
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -13,6 +15,8 @@ import (
 	"github.com/mustaphalimar/go-social/internal/store"
 )
 
+var errInvalidRefreshToken = errors.New("Invalid refresh token.")
+
 type RegisterUserPayload struct {
 	Username string `json:"username" validate:"required,max=100"`
 	Email    string `json:"email" validate:"required,email,max=255"`
@@ -55,6 +59,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 			Name: "user",
 		},
 	}
+	// the language the user signs up in becomes its preferred one
+	if r.Header.Get("Accept-Language") != "" {
+		user.Locale = getLocaleFromContext(r)
+	}
 	// hash the password
 	if err := user.Password.Set(payload.Password); err != nil {
 		app.internalServerResponse(w, r, err)
@@ -67,11 +75,17 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	plainTextToken := uuid.New().String()
 
 	// hashing the token
-	hash := sha256.Sum256([]byte(plainTextToken))
-	hashedToken := hex.EncodeToString(hash[:])
+	hashedToken := hashToken(plainTextToken)
+
+	// the welcome email is queued along with the user, and sent by the mail workers
+	welcome, err := app.activationMail(r, user, plainTextToken)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
 
 	// storing the user
-	err := app.store.Users.CreateAndInvite(ctx, user, hashedToken, app.config.mail.exp)
+	err = app.store.Users.CreateAndInvite(ctx, user, hashedToken, app.config.mail.exp, welcome)
 	if err != nil {
 		switch err {
 		case store.ErrDuplicateEmail:
@@ -89,8 +103,13 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Token: plainTextToken,
 	}
 
-	// sending the verification email
+	if err := app.jsonResponse(w, http.StatusCreated, userWithToken); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
 
+// activationMail is the welcome email holding the activation link of the invitation token
+func (app *application) activationMail(r *http.Request, user *store.User, plainTextToken string) (*store.MailJob, error) {
 	activationUrl := fmt.Sprintf("%s/confirm/%s", app.config.clientURL, plainTextToken)
 	isProdEnv := app.config.env == "production"
 	vars := struct {
@@ -101,20 +120,56 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		ActivationURL: activationUrl,
 	}
 
-	status, err := app.mailer.Send(mailer.UserWelcomeTemplate, user.Username, user.Email, vars, !isProdEnv)
-	if err != nil {
-		app.logger.Errorw("Error while sending the welcome email", "error", err)
-		// rollback user creation if email fails (SAGA pattern)
-		if err := app.store.Users.Delete(ctx, user.ID); err != nil {
-			app.logger.Errorw("Error while deleting user", "error", err)
-		}
+	return store.NewMailJob(mailer.UserWelcomeTemplate, mailLocale(r, user), user.Username, user.Email, vars, !isProdEnv)
+}
+
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
 
+// resendActivationHandler godoc
+//
+//	@Summary		Resends the activation email
+//	@Description	Replaces the invitation of an account that isn't activated yet and sends a new activation email. The response is the same whether or not the email belongs to such an account, or an email was sent to it recently.
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResendActivationPayload	true	"Account email"
+//	@Success		202		{string}	string					"Activation email sent if the account is pending"
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/auth/activation/resend [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	plainTextToken := uuid.New().String()
+
+	_, err := app.store.Users.RotateInvitation(r.Context(), payload.Email, hashToken(plainTextToken), app.config.mail.exp, app.config.mail.resendCooldown,
+		func(user *store.User) (*store.MailJob, error) {
+			return app.activationMail(r, user, plainTextToken)
+		},
+	)
+	switch err {
+	case nil, store.ErrorNotFound, store.ErrTooSoon:
+		// the account may be unknown, already active or sent an email recently, the client doesn't get to know
+	default:
 		app.internalServerResponse(w, r, err)
 		return
 	}
-	app.logger.Infow("Email sent", "Status Code", status)
 
-	if err := app.jsonResponse(w, http.StatusCreated, userWithToken); err != nil {
+	data := map[string]string{
+		"message": "If this email belongs to an account waiting for activation, a new activation link has been sent to it.",
+	}
+	if err := app.jsonResponse(w, http.StatusAccepted, data); err != nil {
 		app.internalServerResponse(w, r, err)
 	}
 }
@@ -124,17 +179,27 @@ type CreateUserTokenPayload struct {
 	Password string `json:"password" validate:"required,min=3,max=72"`
 }
 
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
 // createTokenHandler godoc
 //
 //	@Summary		Creates a token
-//	@Description	Creates a token for a user
+//	@Description	Creates a short-lived access token and a refresh token for a user.
+//	@Description	Users with 2FA enabled get a challenge token instead, to exchange at /auth/token/2fa.
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		CreateUserTokenPayload	true	"User credentials"
-//	@Success		200		{string}	string					"Token"
+//	@Success		201		{object}	TokenPair				"Tokens"
+//	@Success		200		{object}	TwoFactorChallenge		"2FA required"
 //	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
+//	@Failure		401		{object}	error	"Invalid email or password"
+//	@Failure		429		{object}	error	"Too many failed attempts"
 //	@Failure		500		{object}	error
 //	@Router			/auth/token [post]
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -150,33 +215,235 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	ctx := r.Context()
+
+	// too many failures lock the account, or the IP, for a while
+	retryAfter, err := app.checkLoginLockout(ctx, payload.Email, clientIP(r))
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.recordLogin(r, nil, payload.Email, store.LoginLockedOut)
+		app.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
+
 	// fetch the user (check if the user exists) from the payload
-	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrorNotFound:
-			app.notFoundResponse(w, r, err)
+			// compare anyway, an unknown email must take as long to reject as a wrong password
+			(&store.User{}).Password.Compare(payload.Password)
+			app.recordLogin(r, nil, payload.Email, store.LoginUnknownEmail)
+			app.unauthorizedResponse(w, r, errInvalidCredentials)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Password.Compare(payload.Password) {
+		app.recordLogin(r, user, payload.Email, store.LoginInvalidPassword)
+		app.unauthorizedResponse(w, r, errInvalidCredentials)
+		return
+	}
+
+	// users with 2FA get a challenge to exchange along with a code at /auth/token/2fa
+	twoFactor, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if twoFactor.Enabled {
+		challenge, err := app.newTwoFactorChallenge(user.ID)
+		if err != nil {
+			app.internalServerResponse(w, r, err)
+			return
+		}
+
+		app.recordLogin(r, user, payload.Email, store.LoginTwoFactorRequired)
+		if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	tokens, err := app.startSession(ctx, user.ID)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+	app.recordLogin(r, user, payload.Email, store.LoginSuccess)
+
+	// send the tokens to the client
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=255"`
+}
+
+// refreshTokenHandler godoc
+//
+//	@Summary		Refreshes a token
+//	@Description	Exchanges a refresh token for a new access token and a new refresh token.
+//	@Description	Refresh tokens are single use, replaying one revokes every token issued from the same login.
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RefreshTokenPayload	true	"Refresh token"
+//	@Success		201		{object}	TokenPair			"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/auth/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	plainRefreshToken := uuid.New().String()
+	next := &store.RefreshToken{
+		Token:     hashToken(plainRefreshToken),
+		ExpiresAt: time.Now().Add(app.config.auth.jwt.refreshExp),
+	}
+
+	err := app.store.Tokens.RotateRefreshToken(r.Context(), hashToken(payload.RefreshToken), next)
+	if err != nil {
+		switch err {
+		case store.ErrTokenReused:
+			app.loggerFrom(r.Context()).Warnw("Refresh token reused, token family revoked")
+			app.unauthorizedResponse(w, r, errInvalidRefreshToken)
+		case store.ErrorNotFound, store.ErrTokenExpired:
+			app.unauthorizedResponse(w, r, errInvalidRefreshToken)
 		default:
 			app.internalServerResponse(w, r, err)
 		}
 		return
 	}
+
+	tokens, err := app.newTokenPair(next.UserID, plainRefreshToken)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=255"`
+}
+
+// logoutHandler godoc
+//
+//	@Summary		Logs out
+//	@Description	Revokes the access token used for the request and the refresh token issued with it
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		LogoutPayload	true	"Refresh token"
+//	@Success		204		{string}	string			"Logged out"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/auth/logout [post]
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LogoutPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	claims := getClaimsFromContext(r)
+
+	if _, err := app.store.Tokens.RevokeRefreshTokenFamily(ctx, user.ID, hashToken(payload.RefreshToken)); err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if _, err := app.store.Tokens.RevokeAccessToken(ctx, jti, exp.Time); err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startSession starts a new refresh token family for the user, as every login does
+func (app *application) startSession(ctx context.Context, userId int64) (*TokenPair, error) {
+	plainRefreshToken := uuid.New().String()
+	refreshToken := &store.RefreshToken{
+		UserID:    userId,
+		FamilyID:  uuid.New().String(),
+		Token:     hashToken(plainRefreshToken),
+		ExpiresAt: time.Now().Add(app.config.auth.jwt.refreshExp),
+	}
+	if err := app.store.Tokens.CreateRefreshToken(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	return app.newTokenPair(userId, plainRefreshToken)
+}
+
+// newTokenPair signs a new access token for the user and pairs it with an already stored refresh token
+func (app *application) newTokenPair(userId int64, plainRefreshToken string) (*TokenPair, error) {
+	now := time.Now()
+
 	// generate the token -> add claims
 	claims := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": time.Now().Add(app.config.auth.jwt.exp).Unix(),
-		"iat": time.Now().Unix(),
-		"nbf": time.Now().Unix(),
+		"sub": userId,
+		"jti": uuid.New().String(),
+		"exp": now.Add(app.config.auth.jwt.exp).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
 		"iss": app.config.auth.jwt.iss,
 		"aud": app.config.auth.jwt.iss,
 	}
-	token, err := app.authenticator.GenerateToken(claims)
+	accessToken, err := app.authenticator.GenerateToken(claims)
 	if err != nil {
-		app.internalServerResponse(w, r, err)
+		return nil, err
 	}
 
-	// send the token to the client
-	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
-		app.internalServerResponse(w, r, err)
-	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: plainRefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(app.config.auth.jwt.exp.Seconds()),
+	}, nil
+}
+
+// hashToken is how tokens sent to users are stored, only the sha256 of the token ever reaches the database
+func hashToken(plainTextToken string) string {
+	hash := sha256.Sum256([]byte(plainTextToken))
+	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+type commentKey string
+
+const commentCtx commentKey = "comment"
+
+type CreateCommentPayload struct {
+	Content  string `json:"content" validate:"required,max=1000"`
+	ParentID *int64 `json:"parent_id" validate:"omitempty,gt=0"`
+}
+
+// createCommentHandler godoc
+//
+//	@Summary		Creates a comment
+//	@Description	Creates a comment on a post, or a reply to another comment when parent_id is set
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			postId	path		int						true	"Post ID"
+//	@Param			payload	body		CreateCommentPayload	true	"Comment payload"
+//	@Success		201		{object}	store.Comment
+//	@Failure		400		{object}	error	"Invalid input"
+//	@Failure		404		{object}	error	"Post or parent comment not found"
+//	@Failure		500		{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postId}/comments [post]
+func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromCtx(r)
+
+	comment := &store.Comment{
+		PostID:   post.ID,
+		UserID:   user.ID,
+		ParentID: payload.ParentID,
+		Content:  payload.Content,
+		User: store.User{
+			ID:       user.ID,
+			Username: user.Username,
+		},
+	}
+
+	if err := app.store.Comments.Create(r.Context(), comment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrCommentTooDeep):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// getCommentsHandler godoc
+//
+//	@Summary		Lists comments
+//	@Description	Lists a page of the top level comments of a post, newest first, each with a few levels of replies
+//	@Tags			comments
+//	@Produce		json
+//	@Param			postId			path		int	true	"Post ID"
+//	@Param			limit			query		int	false	"Number of top level comments"
+//	@Param			offset			query		int	false	"Offset for pagination"
+//	@Param			depth			query		int	false	"Levels of replies to include"
+//	@Param			replies_limit	query		int	false	"Replies to include per comment"
+//	@Success		200				{array}		store.Comment
+//	@Failure		400				{object}	error	"Invalid query parameters"
+//	@Failure		404				{object}	error	"Post not found"
+//	@Failure		500				{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postId}/comments [get]
+func (app *application) getCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeCommentThread(w, r, nil)
+}
+
+// getCommentRepliesHandler godoc
+//
+//	@Summary		Lists replies
+//	@Description	Lists a page of the replies to a comment, newest first, each with a few levels of replies
+//	@Tags			comments
+//	@Produce		json
+//	@Param			postId			path		int	true	"Post ID"
+//	@Param			commentId		path		int	true	"Comment ID"
+//	@Param			limit			query		int	false	"Number of replies"
+//	@Param			offset			query		int	false	"Offset for pagination"
+//	@Param			depth			query		int	false	"Levels of nested replies to include"
+//	@Param			replies_limit	query		int	false	"Nested replies to include per reply"
+//	@Success		200				{array}		store.Comment
+//	@Failure		400				{object}	error	"Invalid query parameters"
+//	@Failure		404				{object}	error	"Comment not found"
+//	@Failure		500				{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postId}/comments/{commentId}/replies [get]
+func (app *application) getCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+	app.writeCommentThread(w, r, &comment.ID)
+}
+
+// writeCommentThread responds with a page of the subtree under parentId, the whole post when nil
+func (app *application) writeCommentThread(w http.ResponseWriter, r *http.Request, parentId *int64) {
+	fq := store.PaginatedCommentsQuery{
+		Limit:        20,
+		Offset:       0,
+		Depth:        2,
+		RepliesLimit: 3,
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	post := getPostFromCtx(r)
+
+	thread, err := app.store.Comments.GetThread(r.Context(), post.ID, parentId, fq)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, thread); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type UpdateCommentPayload struct {
+	Content string `json:"content" validate:"required,max=1000"`
+}
+
+// updateCommentHandler godoc
+//
+//	@Summary		Updates a comment
+//	@Description	Updates the content of a comment
+//	@Tags			comments
+//	@Accept			json
+//	@Produce		json
+//	@Param			postId		path		int						true	"Post ID"
+//	@Param			commentId	path		int						true	"Comment ID"
+//	@Param			payload		body		UpdateCommentPayload	true	"Updated comment payload"
+//	@Success		200			{object}	store.Comment
+//	@Failure		400			{object}	error	"Invalid input"
+//	@Failure		403			{object}	error	"Forbidden"
+//	@Failure		404			{object}	error	"Comment not found"
+//	@Failure		500			{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postId}/comments/{commentId} [patch]
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	var payload UpdateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment.Content = payload.Content
+
+	if err := app.store.Comments.Update(r.Context(), comment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// deleteCommentHandler godoc
+//
+//	@Summary		Deletes a comment
+//	@Description	Deletes a comment by its ID, along with its replies
+//	@Tags			comments
+//	@Produce		json
+//	@Param			postId		path	int	true	"Post ID"
+//	@Param			commentId	path	int	true	"Comment ID"
+//	@Success		204			"No Content"
+//	@Failure		403			{object}	error	"Forbidden"
+//	@Failure		404			{object}	error	"Comment not found"
+//	@Failure		500			{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postId}/comments/{commentId} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromCtx(r)
+
+	if err := app.store.Comments.Delete(r.Context(), comment.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commentsContextMiddleware fetches the comment and makes sure it belongs to the post in the context
+func (app *application) commentsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commentId, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		ctx := r.Context()
+
+		comment, err := app.store.Comments.GetById(ctx, commentId)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerResponse(w, r, err)
+			}
+			return
+		}
+
+		post := getPostFromCtx(r)
+		if comment.PostID != post.ID {
+			app.notFoundResponse(w, r, store.ErrorNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommentFromCtx(r *http.Request) *store.Comment {
+	comment, _ := r.Context().Value(commentCtx).(*store.Comment)
+	return comment
+}
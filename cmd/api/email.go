@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+var errInvalidPassword = errors.New("Invalid password.")
+
+type ChangeEmailPayload struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,max=72"`
+}
+
+// changeEmailHandler godoc
+//
+//	@Summary		Requests an email change
+//	@Description	Emails a confirmation link to the new address and a notice to the current one. The email only changes once the link is followed.
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangeEmailPayload	true	"New email and current password"
+//	@Success		202		{string}	string				"Confirmation email sent"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error	"Invalid password"
+//	@Failure		409		{object}	error	"Email already in use"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/email [post]
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if !user.Password.Compare(payload.Password) {
+		app.unauthorizedResponse(w, r, errInvalidPassword)
+		return
+	}
+
+	plainTextToken := uuid.New().String()
+	isProdEnv := app.config.env == "production"
+
+	confirmVars := struct {
+		Username   string
+		ConfirmURL string
+		ExpiresIn  string
+	}{
+		Username:   user.Username,
+		ConfirmURL: fmt.Sprintf("%s/confirm-email/%s", app.config.clientURL, plainTextToken),
+		ExpiresIn:  i18n.Duration(mailLocale(r, user), app.config.mail.emailExp),
+	}
+	confirm, err := store.NewMailJob(mailer.EmailChangeTemplate, mailLocale(r, user), user.Username, payload.Email, confirmVars, !isProdEnv)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	noticeVars := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: payload.Email,
+	}
+	notice, err := store.NewMailJob(mailer.EmailChangeNoticeTemplate, mailLocale(r, user), user.Username, user.Email, noticeVars, !isProdEnv)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	err = app.store.Users.CreateEmailChange(r.Context(), user.ID, payload.Email, hashToken(plainTextToken), app.config.mail.emailExp, confirm, notice)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	data := map[string]string{
+		"message": "A confirmation link has been sent to the new email address.",
+	}
+	if err := app.jsonResponse(w, http.StatusAccepted, data); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// confirmEmailHandler godoc
+//
+//	@Summary		Confirms an email change
+//	@Description	Switches the account to its new email with the token from the confirmation email
+//	@Tags			users
+//	@Produce		json
+//	@Param			token	path		string	true	"Email change token"
+//	@Success		204		{string}	string	"Email changed"
+//	@Failure		404		{object}	error	"Invalid or expired token"
+//	@Failure		409		{object}	error	"Email already in use"
+//	@Failure		500		{object}	error
+//	@Router			/users/email/{token} [put]
+func (app *application) confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	if err := app.store.Users.ConfirmEmailChange(r.Context(), hashToken(token)); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFoundResponse(w, r, err)
+		case store.ErrDuplicateEmail:
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,7 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/store"
 )
 
 const (
@@ -9,46 +16,119 @@ const (
 	ColorReset = "\033[0m"
 )
 
+// errorCodes are the stable codes of the errors clients may want to tell apart, other errors getting the
+// code of their response. The first target an error matches gives its code, so the errors wrapping others
+// come before them, and the generic ones of the store last.
+var errorCodes = []struct {
+	target error
+	code   string
+}{
+	{store.ErrDuplicateEmail, "EMAIL_IN_USE"},
+	{store.ErrDuplicateUsername, "USERNAME_IN_USE"},
+	{store.ErrCommentTooDeep, "COMMENT_TOO_DEEP"},
+	{store.ErrInvalidCursor, "INVALID_CURSOR"},
+	{store.ErrTokenExpired, "TOKEN_EXPIRED"},
+	{store.ErrTokenReused, "TOKEN_REUSED"},
+	{errInvalidCredentials, "INVALID_CREDENTIALS"},
+	{errInvalidSecondFactor, "INVALID_SECOND_FACTOR"},
+	{errProfileModified, "PROFILE_MODIFIED"},
+	{errInvalidLimit, "INVALID_LIMIT"},
+	{errInvalidPassword, "INVALID_PASSWORD"},
+	{errInvalidRefreshToken, "INVALID_REFRESH_TOKEN"},
+	{errInvalidToken, "INVALID_TOKEN"},
+	{errAuthHeaderMissing, "AUTH_HEADER_MISSING"},
+	{errAuthHeaderMalformed, "AUTH_HEADER_MALFORMED"},
+	{errNotAccessToken, "NOT_AN_ACCESS_TOKEN"},
+	{errTokenMissingID, "TOKEN_MISSING_ID"},
+	{errTokenRevoked, "TOKEN_REVOKED"},
+	{errInvalidBasicAuth, "INVALID_BASIC_AUTH_CREDENTIALS"},
+	{errNotTwoFactorChallenge, "NOT_A_TWO_FACTOR_CHALLENGE"},
+	{errTwoFactorEnabled, "TWO_FACTOR_ENABLED"},
+	{errTwoFactorNotEnabled, "TWO_FACTOR_NOT_ENABLED"},
+	{errTwoFactorNotStarted, "TWO_FACTOR_NOT_STARTED"},
+	{errTwoFactorChanged, "TWO_FACTOR_CHANGED"},
+	{errInvalidPreviewFormat, "INVALID_PREVIEW_FORMAT"},
+	{store.ErrInvalidTimeWindow, "INVALID_TIME_WINDOW"},
+	{store.ErrConflict, "ALREADY_EXISTS"},
+	{store.ErrorNotFound, "RECORD_NOT_FOUND"},
+}
+
+func errorCode(err error, fallback string) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.target) {
+			return c.code
+		}
+	}
+	return fallback
+}
+
+// writeError writes the problem of the given status, its detail being the message of the code, a format with
+// args, in the locale of the request. Errors are only logged, their messages not being meant for clients.
+func (app *application) writeError(w http.ResponseWriter, r *http.Request, status int, code string, args ...any) {
+	app.writeProblem(w, r, &problem{
+		Status: status,
+		Code:   code,
+		Detail: i18n.T(getLocaleFromContext(r), code, args...),
+	})
+}
+
 func (app *application) internalServerResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "INTERNAL_SERVER_ERROR"
-	app.logger.Errorw(message, "method", r.Method, "path", r.URL.Path, err)
-	writeJSONError(w, http.StatusInternalServerError, "The server encountered a problem while procession your request.")
+	app.loggerFrom(r.Context()).Errorw(message, "error", err)
+	app.writeError(w, r, http.StatusInternalServerError, message)
 }
 
 func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "CONFLICT_ERROR"
-	app.logger.Warnf(message, "method", r.Method, "path", r.URL.Path, err)
-	writeJSONError(w, http.StatusConflict, err.Error())
+	app.loggerFrom(r.Context()).Warnw(message, "error", err)
+	app.writeError(w, r, http.StatusConflict, errorCode(err, message))
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "BAD_REQUEST_ERROR"
-	app.logger.Warnf(message, "method", r.Method, "path", r.URL.Path, err)
-	writeJSONError(w, http.StatusBadRequest, err.Error())
+	app.loggerFrom(r.Context()).Warnw(message, "error", err)
+	app.writeProblem(w, r, badRequestProblem(getLocaleFromContext(r), err))
 }
 
 func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request) {
 	var message = "FORBIDDEN_ERROR"
-	app.logger.Warnf(message, "method", r.Method, "path", r.URL.Path)
-	writeJSONError(w, http.StatusForbidden, "This action is forbidden")
+	app.loggerFrom(r.Context()).Warnw(message)
+	app.writeError(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) insufficientScopeResponse(w http.ResponseWriter, r *http.Request, scope string) {
+	var message = "INSUFFICIENT_SCOPE_ERROR"
+	app.loggerFrom(r.Context()).Warnw(message, "scope", scope)
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+	app.writeError(w, r, http.StatusForbidden, message, scope)
+}
+
+func (app *application) tooManyRequestsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	var message = "TOO_MANY_REQUESTS_ERROR"
+	app.loggerFrom(r.Context()).Warnw(message)
+
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	app.writeError(w, r, http.StatusTooManyRequests, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "NOT_FOUND_ERROR"
-	app.logger.Warnf(message, "method", r.Method, "path", r.URL.Path, err)
-	writeJSONError(w, http.StatusNotFound, "Resource not found.")
+	app.loggerFrom(r.Context()).Warnw(message, "error", err)
+	app.writeError(w, r, http.StatusNotFound, message)
 }
 
 func (app *application) unauthorizedResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "UNAUTHORIZED_ERROR"
-	app.logger.Errorw(message, "method", r.Method, "path", r.URL.Path, err)
+	app.loggerFrom(r.Context()).Errorw(message, "error", err)
 
-	writeJSONError(w, http.StatusUnauthorized, err.Error())
+	app.writeError(w, r, http.StatusUnauthorized, errorCode(err, message))
 }
+
 func (app *application) unauthorizedBasicAuthResponse(w http.ResponseWriter, r *http.Request, err error) {
 	var message = "UNAUTHORIZED_BASIC_AUTH_ERROR"
-	app.logger.Errorw(message, "method", r.Method, "path", r.URL.Path, err)
+	app.loggerFrom(r.Context()).Errorw(message, "error", err)
 
 	w.Header().Set("WWW-Authenticate", `Basic realm="restriced", charset="UTF-8"`)
-	writeJSONError(w, http.StatusUnauthorized, err.Error())
+	app.writeError(w, r, http.StatusUnauthorized, errorCode(err, message))
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"go.uber.org/zap"
+)
+
+func TestErrorCodeFollowsTheOrderOfTheTargets(t *testing.T) {
+	err := errors.Join(store.ErrorNotFound, fmt.Errorf("saving: %w", store.ErrDuplicateEmail), store.ErrConflict)
+
+	for range 20 {
+		if code := errorCode(err, "CONFLICT_ERROR"); code != "EMAIL_IN_USE" {
+			t.Fatalf("errorCode = %s, want EMAIL_IN_USE", code)
+		}
+	}
+}
+
+func TestErrorResponsesDontShowErrors(t *testing.T) {
+	app := &application{logger: zap.NewNop().Sugar()}
+	err := errors.New("token has invalid claims: token is expired")
+
+	responses := map[string]func(w *httptest.ResponseRecorder){
+		"UNAUTHORIZED_ERROR": func(w *httptest.ResponseRecorder) {
+			app.unauthorizedResponse(w, httptest.NewRequest("GET", "/", nil), err)
+		},
+		"UNAUTHORIZED_BASIC_AUTH_ERROR": func(w *httptest.ResponseRecorder) {
+			app.unauthorizedBasicAuthResponse(w, httptest.NewRequest("GET", "/", nil), err)
+		},
+		"CONFLICT_ERROR": func(w *httptest.ResponseRecorder) {
+			app.conflictResponse(w, httptest.NewRequest("GET", "/", nil), err)
+		},
+	}
+
+	for code, respond := range responses {
+		t.Run(code, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			respond(w)
+
+			var p problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != code {
+				t.Errorf("code = %s, want %s", p.Code, code)
+			}
+			if p.Detail == "" || p.Detail == code || p.Detail == err.Error() {
+				t.Errorf("detail = %q, want the message of %s", p.Detail, code)
+			}
+		})
+	}
+}
+
+func TestEveryErrorCodeHasAMessage(t *testing.T) {
+	for _, c := range errorCodes {
+		for _, locale := range i18n.Supported {
+			if _, ok := i18n.Message(locale, c.code); !ok {
+				t.Errorf("%s has no %s message", c.code, locale)
+			}
+		}
+	}
+}
+
+func TestBadRequestProblemDoesntShowErrors(t *testing.T) {
+	tests := []struct {
+		err    error
+		code   string
+		detail string
+	}{
+		{errors.New("pq: invalid input syntax"), "BAD_REQUEST_ERROR", "The request is invalid."},
+		{&store.ParamError{Name: "limit", Value: "ten"}, "INVALID_QUERY_PARAMETER", `Invalid limit: "ten"`},
+		{store.ErrInvalidDepth, "INVALID_DEPTH", fmt.Sprintf("Invalid depth, at most %d levels of replies can be loaded.", store.MaxCommentDepth)},
+		{fmt.Errorf("parsing: %w", store.ErrInvalidCursor), "INVALID_CURSOR", "Invalid cursor."},
+		{errInvalidPreviewFormat, "INVALID_PREVIEW_FORMAT", "Invalid format, expected json, html or text."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			p := badRequestProblem("en", tt.err)
+			if p.Code != tt.code || p.Detail != tt.detail {
+				t.Errorf("problem = %s %q, want %s %q", p.Code, p.Detail, tt.code, tt.detail)
+			}
+		})
+	}
+}
@@ -14,10 +14,13 @@ import (
 //	@Accept			json
 //	@Produce		json
 //	@Param			limit	query		int		false	"Limit the number of posts"
-//	@Param			offset	query		int		false	"Offset for pagination"
+//	@Param			offset	query		int		false	"Offset for pagination, ignored when a cursor is given"
+//	@Param			cursor	query		string	false	"Opaque cursor from next_cursor or prev_cursor"
 //	@Param			sort	query		string	false	"Sort order (asc or desc)"
 //	@Param			tags	query		string	false	"Tags"
 //	@Param			search	query		string	false	"Search"
+//	@Param			since	query		string	false	"Only posts created at or after this time (RFC 3339 or 2006-01-02 15:04:05)"
+//	@Param			until	query		string	false	"Only posts created before this time (RFC 3339 or 2006-01-02 15:04:05)"
 //	@Success		200		{array}		store.Post
 //	@Failure		400		{object}	error	"Invalid query parameters"
 //	@Failure		500		{object}	error	"Internal server error"
@@ -50,7 +53,9 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
+	setLinkHeader(w, r, feed.NextCursor, feed.PrevCursor)
+
+	if err := app.paginatedJSONResponse(w, http.StatusOK, feed.Posts, feed.NextCursor, feed.PrevCursor); err != nil {
 		app.internalServerResponse(w, r, err)
 	}
 
@@ -1,27 +1,195 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/mustaphalimar/go-social/internal/db"
+	"github.com/mustaphalimar/go-social/internal/health"
+	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+const (
+	healthCheckTimeout = time.Second * 2
+	// healthCheckTTL is how long the results of the checks are reused, /readyz being public and unthrottled
+	healthCheckTTL = time.Second * 5
+
+	statusDraining    = "DRAINING"
+	statusDegraded    = "DEGRADED"
+	statusUnavailable = "UNAVAILABLE"
 )
 
+// newHealthChecks are the dependencies the readiness of the API is checked against
+func newHealthChecks(conn *sql.DB, mailer mailer.Client) []health.Check {
+	return []health.Check{
+		{Name: "database", Checker: health.CheckerFunc(conn.PingContext)},
+		{Name: "migrations", Checker: health.CheckerFunc(func(ctx context.Context) error {
+			version, dirty, err := db.SchemaVersion(ctx, conn)
+			if err != nil {
+				return err
+			}
+			// a newer schema is the one of the release being rolled out, migrated before this one is replaced
+			if dirty || version < store.SchemaVersion {
+				return fmt.Errorf("schema is at version %d (dirty: %t), expected at least %d", version, dirty, store.SchemaVersion)
+			}
+			return nil
+		})},
+		{Name: "mailer", Checker: health.CheckerFunc(mailer.Ping), Optional: true},
+	}
+}
+
+// readiness runs the health checks, telling the status of the API and its HTTP status code
+func (app *application) readiness(ctx context.Context) (health.Report, string, int) {
+	report := app.healthChecks.Run(ctx)
+
+	switch {
+	case app.lifecycle.Draining():
+		return report, statusDraining, http.StatusServiceUnavailable
+	case !report.Ready():
+		return report, statusUnavailable, http.StatusServiceUnavailable
+	case report.Degraded():
+		return report, statusDegraded, http.StatusOK
+	default:
+		return report, health.StatusOK, http.StatusOK
+	}
+}
+
+// livenessHandler godoc
+//
+//	@Summary		Liveness probe
+//	@Description	Tells that the process is up, without checking its dependencies
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	map[string]string
+//	@Router			/livez [get]
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]string{
+		"status": health.StatusOK,
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, data); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// readinessHandler godoc
+//
+//	@Summary		Readiness probe
+//	@Description	Checks the database, the migrations and the mailer, failing while the process shuts down. A failing mailer only degrades the API.
+//	@Tags			health
+//	@Produce		json
+//	@Success		200	{object}	map[string]any
+//	@Failure		503	{object}	map[string]any	"Not ready"
+//	@Router			/readyz [get]
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report, status, code := app.readiness(r.Context())
+
+	// the probe is public, so it doesn't tell why a check failed
+	checks := make(map[string]string, len(report.Results))
+	for _, res := range report.Results {
+		checks[res.Name] = res.Status
+	}
+
+	data := map[string]any{
+		"status": status,
+		"checks": checks,
+	}
+
+	if err := app.jsonResponse(w, code, data); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type healthCheckReport struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Optional  bool    `json:"optional,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+type dbPoolStats struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	InUse              int     `json:"in_use"`
+	Idle               int     `json:"idle"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDurationMs     float64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64   `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64   `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64   `json:"max_lifetime_closed"`
+}
+
+type healthReport struct {
+	Status   string              `json:"status"`
+	Env      string              `json:"env"`
+	Build    buildInfo           `json:"build"`
+	Checks   []healthCheckReport `json:"checks"`
+	Database dbPoolStats         `json:"database"`
+}
+
 // healthCheckHandler godoc
 //
 //	@Summary		Health check
-//	@Description	Returns service status, environment, and version
+//	@Description	Returns service status, environment and build, with the latency of every check and the stats of the database pool
 //	@Tags			health
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{object}	map[string]string
+//	@Success		200	{object}	healthReport
+//	@Failure		503	{object}	healthReport	"Not ready"
 //	@Router			/health [get]
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	data := map[string]string{
-		"status":  "OK",
-		"data":    "",
-		"env":     app.config.env,
-		"version": version,
+	report, status, code := app.readiness(r.Context())
+
+	checks := make([]healthCheckReport, 0, len(report.Results))
+	for _, res := range report.Results {
+		checks = append(checks, healthCheckReport{
+			Name:      res.Name,
+			Status:    res.Status,
+			Optional:  res.Optional,
+			LatencyMs: float64(res.Latency.Microseconds()) / 1000,
+			Error:     res.Error,
+		})
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, data); err != nil {
+	stats := app.db.Stats()
+
+	data := healthReport{
+		Status: status,
+		Env:    app.config.env,
+		Build: buildInfo{
+			Version:   version,
+			Commit:    commit,
+			BuildTime: buildTime,
+			GoVersion: runtime.Version(),
+		},
+		Checks: checks,
+		Database: dbPoolStats{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     float64(stats.WaitDuration.Microseconds()) / 1000,
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		},
+	}
+
+	if err := app.jsonResponse(w, code, data); err != nil {
 		app.internalServerResponse(w, r, err)
 		return
 	}
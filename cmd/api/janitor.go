@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runJanitor periodically purges expired invitations, the accounts that were never activated, old sent and dead emails,
+// stale rate limit windows and the revocations of expired access tokens, until ctx is done
+func (app *application) runJanitor(ctx context.Context) {
+	ticker := time.NewTicker(app.config.janitor.interval)
+	defer ticker.Stop()
+
+	for {
+		app.cleanUp(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (app *application) cleanUp(ctx context.Context) {
+	invitations, err := app.store.Users.DeleteExpiredInvitations(ctx)
+	if err != nil {
+		app.logger.Errorw("Error while deleting expired invitations", "error", err)
+	}
+
+	users, err := app.store.Users.DeleteUnactivated(ctx, time.Now().Add(-app.config.janitor.unactivatedGrace))
+	if err != nil {
+		app.logger.Errorw("Error while deleting unactivated users", "error", err)
+	}
+
+	mails, err := app.store.MailOutbox.DeleteFinishedBefore(ctx, time.Now().Add(-app.config.outbox.retention))
+	if err != nil {
+		app.logger.Errorw("Error while deleting sent and dead emails", "error", err)
+	}
+
+	windows, err := app.rateLimiter.Cleanup(ctx)
+	if err != nil {
+		app.logger.Errorw("Error while deleting rate limit windows", "error", err)
+	}
+
+	revocations, err := app.store.Tokens.DeleteExpiredRevocations(ctx)
+	if err != nil {
+		app.logger.Errorw("Error while deleting expired token revocations", "error", err)
+	}
+
+	if invitations > 0 || users > 0 || mails > 0 || windows > 0 || revocations > 0 {
+		app.logger.Infow("Janitor cleaned up", "invitations", invitations, "users", users, "mails", mails, "rate_limit_windows", windows, "revocations", revocations)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -11,6 +14,16 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	Validate.RegisterValidation("locale", validateLocale)
+
+	// validation errors name fields as the client sent them
+	Validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) error {
@@ -31,14 +44,10 @@ func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	return decoder.Decode(data)
 }
 
-func writeJSONError(w http.ResponseWriter, status int, message string) error {
-	type envelope struct {
-		Error string `json:"error"`
-	}
-
-	return writeJSON(w, status, &envelope{
-		Error: message,
-	})
+func writeProblemJSON(w http.ResponseWriter, p *problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
 }
 
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
@@ -47,3 +56,32 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	}
 	return writeJSON(w, status, &envelope{Data: data})
 }
+
+func (app *application) paginatedJSONResponse(w http.ResponseWriter, status int, data any, nextCursor, prevCursor string) error {
+	type envelope struct {
+		Data       any    `json:"data"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+	}
+	return writeJSON(w, status, &envelope{Data: data, NextCursor: nextCursor, PrevCursor: prevCursor})
+}
+
+// setLinkHeader sets the RFC 5988 Link header pointing at the same request with the given cursors
+func setLinkHeader(w http.ResponseWriter, r *http.Request, nextCursor, prevCursor string) {
+	var links []string
+
+	for _, l := range []struct{ rel, cursor string }{{"next", nextCursor}, {"prev", prevCursor}} {
+		if l.cursor == "" {
+			continue
+		}
+
+		qs := r.URL.Query()
+		qs.Del("offset")
+		qs.Set("cursor", l.cursor)
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, qs.Encode(), l.rel))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
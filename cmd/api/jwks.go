@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mustaphalimar/go-social/internal/auth"
+)
+
+// jwksHandler godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Publishes the public keys access tokens can be verified with, when they are signed with RS256 or EdDSA
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	auth.JWKSet
+//	@Failure		404	{object}	error	"Tokens are signed with a shared secret"
+//	@Router			/.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	publisher, ok := app.authenticator.(auth.KeyPublisher)
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("Authenticator has no public keys"))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := writeJSON(w, http.StatusOK, publisher.JWKS()); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+type localeKey string
+
+const localeCtx localeKey = "locale"
+
+// localeMiddleware resolves the locale of the request from its Accept-Language header
+func (app *application) localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+
+		ctx := context.WithValue(r.Context(), localeCtx, i18n.Match(r.Header.Get("Accept-Language")))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withUserLocale makes the preferred locale of the authenticated user, if it has one, win over Accept-Language
+func withUserLocale(ctx context.Context, user *store.User) context.Context {
+	if user.Locale == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, localeCtx, user.Locale)
+}
+
+// getLocaleFromContext returns the locale responses to the request should be written in
+func getLocaleFromContext(r *http.Request) string {
+	locale, ok := r.Context().Value(localeCtx).(string)
+	if !ok {
+		return i18n.Default
+	}
+	return locale
+}
+
+// mailLocale is the locale of the emails to a user, which is its preferred one, or the one of the request
+// when it has none
+func mailLocale(r *http.Request, user *store.User) string {
+	if user.Locale != "" {
+		return user.Locale
+	}
+	return getLocaleFromContext(r)
+}
+
+// validateLocale accepts the supported locales, and the empty string meaning no preference
+func validateLocale(fl validator.FieldLevel) bool {
+	locale := fl.Field().String()
+	return locale == "" || i18n.IsSupported(locale)
+}
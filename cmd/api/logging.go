@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newLogger builds the JSON logger of the process, and the one of the access log. Sampling keeps the first
+// sampleInitial entries with the same level and message every second, then every sampleThereafter-th one, a
+// zero turning it off. The access log shares the output of the logger without being sampled, as every
+// request logs the same message.
+func newLogger(cfg logConfig) (logger *zap.SugaredLogger, access *zap.SugaredLogger, err error) {
+	level, err := zapcore.ParseLevel(cfg.level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zapCfg := zap.NewProductionConfig()
+	zapCfg.Level = zap.NewAtomicLevelAt(level)
+	zapCfg.Sampling = nil
+
+	base, err := zapCfg.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sampled := base
+	if cfg.sampleThereafter > 0 {
+		sampled = base.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, time.Second, cfg.sampleInitial, cfg.sampleThereafter)
+		}))
+	}
+
+	return sampled.Sugar(), base.Sugar(), nil
+}
+
+type requestLogKey string
+
+const requestLogCtx requestLogKey = "requestLog"
+
+// requestLog is shared by the middlewares of a request, so that the user authenticated deeper in the
+// chain ends up in the access log line
+type requestLog struct {
+	logger *zap.SugaredLogger
+	access *zap.SugaredLogger
+}
+
+// requestLogger puts a logger tagged with the request and trace ids in the context of the request, then logs
+// the request once served
+func (app *application) requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		fields := append(traceFields(r.Context()), "request_id", middleware.GetReqID(r.Context()))
+		rl := &requestLog{
+			logger: app.logger.With(fields...),
+			access: app.accessLogger.With(fields...),
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), requestLogCtx, rl)))
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		fields = []any{
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency", time.Since(start),
+			"remote_ip", clientIP(r),
+		}
+		if status >= http.StatusInternalServerError {
+			rl.access.Errorw("Request", fields...)
+		} else {
+			rl.access.Infow("Request", fields...)
+		}
+	})
+}
+
+// setLogUser tags the log lines of the request with the id of the authenticated user
+func setLogUser(ctx context.Context, user *store.User) {
+	rl, ok := ctx.Value(requestLogCtx).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.logger = rl.logger.With("user_id", user.ID)
+	rl.access = rl.access.With("user_id", user.ID)
+}
+
+// loggerFrom returns the logger of the request ctx belongs to, or the one of its span outside of requests
+func (app *application) loggerFrom(ctx context.Context) *zap.SugaredLogger {
+	if rl, ok := ctx.Value(requestLogCtx).(*requestLog); ok {
+		return rl.logger
+	}
+	return app.loggerWithTrace(ctx)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+const (
+	// after accountLockoutThreshold failed logins an account is locked for accountLockoutBase,
+	// doubling with every further failure up to accountLockoutMax, until a successful login
+	accountLockoutThreshold = 5
+	accountLockoutBase      = time.Minute
+	accountLockoutMax       = time.Hour
+	accountFailuresWindow   = time.Hour * 24
+
+	// an IP failing more than ipFailuresLimit logins in ipFailuresWindow is blocked until the window moves on
+	ipFailuresLimit  = 20
+	ipFailuresWindow = time.Minute * 15
+)
+
+var (
+	errInvalidCredentials = errors.New("Invalid email or password.")
+	errInvalidLimit       = errors.New("Invalid limit, expected a number between 1 and 100.")
+)
+
+// lockoutDuration is how long an account stays locked after its last failed login
+func lockoutDuration(failures int) time.Duration {
+	if failures < accountLockoutThreshold {
+		return 0
+	}
+
+	d := accountLockoutBase
+	for i := accountLockoutThreshold; i < failures && d < accountLockoutMax; i++ {
+		d *= 2
+	}
+	return min(d, accountLockoutMax)
+}
+
+// checkLoginLockout returns how long the client has to wait before trying to log into the account again, 0 meaning it can
+func (app *application) checkLoginLockout(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	ipFailures, err := app.store.Logins.IPFailures(ctx, ip, now.Add(-ipFailuresWindow))
+	if err != nil {
+		return 0, err
+	}
+	if ipFailures >= ipFailuresLimit {
+		return ipFailuresWindow, nil
+	}
+
+	failures, lastFailure, err := app.store.Logins.AccountFailures(ctx, email, now.Add(-accountFailuresWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	lockedUntil := lastFailure.Add(lockoutDuration(failures))
+	if failures >= accountLockoutThreshold && lockedUntil.After(now) {
+		return lockedUntil.Sub(now), nil
+	}
+
+	return 0, nil
+}
+
+// recordLogin audits a login attempt, a failure to do so is logged but doesn't fail the login
+func (app *application) recordLogin(r *http.Request, user *store.User, email, outcome string) {
+	event := &store.LoginEvent{
+		Email:     email,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+
+	if err := app.store.Logins.Record(r.Context(), event); err != nil {
+		app.loggerFrom(r.Context()).Errorw("Error while recording login event", "error", err, "outcome", outcome)
+	}
+}
+
+// clientIP is the address app.realIP resolved, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getLoginsHandler godoc
+//
+//	@Summary		Lists recent logins
+//	@Description	Lists the recent login attempts on the account of the authenticated user, newest first
+//	@Tags			users
+//	@Produce		json
+//	@Param			limit	query		int	false	"Number of events (max 100)"
+//	@Success		200		{array}		store.LoginEvent
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/logins [get]
+func (app *application) getLoginsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 100 {
+			app.badRequestResponse(w, r, errInvalidLimit)
+			return
+		}
+		limit = parsed
+	}
+
+	user := getUserFromContext(r)
+
+	events, err := app.store.Logins.GetByUserId(r.Context(), user.ID, limit)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, events); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"time"
 
@@ -8,12 +11,20 @@ import (
 	"github.com/mustaphalimar/go-social/internal/auth"
 	"github.com/mustaphalimar/go-social/internal/db"
 	"github.com/mustaphalimar/go-social/internal/env"
+	"github.com/mustaphalimar/go-social/internal/health"
+	"github.com/mustaphalimar/go-social/internal/lifecycle"
 	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/ratelimiter"
 	"github.com/mustaphalimar/go-social/internal/store"
 	"go.uber.org/zap"
 )
 
-const version = "0.0.1"
+// set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildTime=...", see the Makefile
+var (
+	version   = "0.0.1"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
 
 //	@title			Swagger Example API
 //	@description	Go-Social Docs
@@ -48,11 +59,23 @@ func main() {
 			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 30),
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
 		},
-		env: env.GetString("ENV", "development"),
+		env:            env.GetString("ENV", "development"),
+		trustedProxies: env.GetStrings("TRUSTED_PROXIES", nil),
 		mail: mailConfig{
-			exp:       time.Hour * 24 * 3, // days
-			apiKey:    env.GetString("SENDGRID_API_KEY", ""),
-			fromEmail: env.GetString("FROM_EMAIL", ""),
+			exp:            time.Hour * 24 * 3, // days
+			resetExp:       time.Hour,
+			emailExp:       time.Hour * 24,
+			resendCooldown: time.Minute * 5,
+			fromEmail:      env.GetString("FROM_EMAIL", ""),
+			backend:        env.GetString("MAILER", "sendgrid"),
+			apiKey:         env.GetString("SENDGRID_API_KEY", ""),
+			smtp: smtpConfig{
+				host:     env.GetString("SMTP_HOST", "localhost"),
+				port:     env.GetInt("SMTP_PORT", 587),
+				username: env.GetString("SMTP_USERNAME", ""),
+				password: env.GetString("SMTP_PASSWORD", ""),
+			},
+			dir: env.GetString("MAIL_DIR", "tmp/mail"),
 		},
 		auth: authConfig{
 			basic: basicConfig{
@@ -60,16 +83,85 @@ func main() {
 				password: env.GetString("BASIC_AUTH_PASSWORD", ""),
 			},
 			jwt: jwtConfig{
-				secret: env.GetString("JWT_SECRET", ""),
-				exp:    time.Hour * 24 * 3, // days
-				iss:    "go-social",
+				secret:     env.GetString("JWT_SECRET", ""),
+				exp:        time.Minute * 15,
+				refreshExp: time.Hour * 24 * 30, // days
+				iss:        "go-social",
+				// PEM files, the verification keys being the ones still trusted during a rotation
+				signingKeyFile:       env.GetString("JWT_SIGNING_KEY_FILE", ""),
+				verificationKeyFiles: env.GetStrings("JWT_VERIFICATION_KEY_FILES", nil),
+			},
+		},
+		outbox: outboxConfig{
+			workers:      env.GetInt("MAIL_WORKERS", 2),
+			pollInterval: env.GetDuration("MAIL_POLL_INTERVAL", time.Second*2),
+			lease:        time.Minute,
+			maxAttempts:  env.GetInt("MAIL_MAX_ATTEMPTS", 8),
+			backoffBase:  time.Second * 10,
+			backoffMax:   time.Hour,
+			retention:    time.Hour * 24 * 7, // days
+		},
+		rateLimit: rateLimitConfig{
+			enabled: env.GetBool("RATE_LIMIT_ENABLED", true),
+			backend: env.GetString("RATE_LIMIT_BACKEND", "memory"),
+			auth: ratelimiter.Limit{
+				Requests: env.GetInt("RATE_LIMIT_AUTH_REQUESTS", 10),
+				Window:   env.GetDuration("RATE_LIMIT_AUTH_WINDOW", time.Minute),
+			},
+			client: ratelimiter.Limit{
+				Requests: env.GetInt("RATE_LIMIT_CLIENT_REQUESTS", 1200),
+				Window:   env.GetDuration("RATE_LIMIT_CLIENT_WINDOW", time.Minute),
 			},
+			api: ratelimiter.Limit{
+				Requests: env.GetInt("RATE_LIMIT_API_REQUESTS", 300),
+				Window:   env.GetDuration("RATE_LIMIT_API_WINDOW", time.Minute),
+			},
+		},
+		tracing: tracingConfig{
+			exporter:    env.GetString("TRACING_EXPORTER", "none"),
+			serviceName: env.GetString("OTEL_SERVICE_NAME", "go-social"),
+		},
+		log: logConfig{
+			level:            env.GetString("LOG_LEVEL", "info"),
+			sampleInitial:    env.GetInt("LOG_SAMPLE_INITIAL", 100),
+			sampleThereafter: env.GetInt("LOG_SAMPLE_THEREAFTER", 100),
+		},
+		shutdown: shutdownConfig{
+			delay:   env.GetDuration("SHUTDOWN_DELAY", 0),
+			timeout: env.GetDuration("SHUTDOWN_TIMEOUT", time.Second*30),
+		},
+		janitor: janitorConfig{
+			interval:         env.GetDuration("JANITOR_INTERVAL", time.Hour),
+			unactivatedGrace: env.GetDuration("UNACTIVATED_ACCOUNT_GRACE", time.Hour*24*30),
 		},
 	}
 	// Logger
-	logger := zap.Must(zap.NewProduction()).Sugar()
+	logger, accessLogger, err := newLogger(cfg.log)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer logger.Sync()
 
+	if err := validateIntervals(cfg); err != nil {
+		logger.Fatal(err)
+	}
+
+	// Tracing, before the database whose statements are traced
+	tracerProvider, err := newTracerProvider(context.Background(), cfg.tracing, cfg.env)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if tracerProvider != nil {
+		defer func() {
+			// flushes the spans still waiting to be exported
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if err := tracerProvider.Shutdown(ctx); err != nil {
+				logger.Errorw("Error while flushing spans", "error", err)
+			}
+		}()
+	}
+
 	// Database
 	db, err := db.New(cfg.db.addr, cfg.db.maxOpenConns, cfg.db.maxIdleConns, cfg.db.maxIdleTime)
 	if err != nil {
@@ -78,21 +170,126 @@ func main() {
 	defer db.Close()
 	logger.Info("Database connection pool established.")
 
-	store := store.NewStorage(db)
+	metrics := newMetrics(db)
+
+	store := store.Instrument(store.Instrument(store.NewStorage(db), metrics.observeStore), traceStore)
+
+	mailer, err := newMailer(cfg.mail, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	mailer = &instrumentedMailer{Client: mailer, metrics: metrics}
+
+	authenticator, err := newAuthenticator(cfg.auth.jwt)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	mailer := mailer.NewSendgrid(cfg.mail.apiKey, cfg.mail.fromEmail)
+	rateLimiter, err := newRateLimiter(cfg.rateLimit, db)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
-	jwtAuthenticator := auth.NewJWTAuthenticator(cfg.auth.jwt.secret, cfg.auth.jwt.iss, cfg.auth.jwt.iss)
+	trustedProxies, err := parseTrustedProxies(cfg.trustedProxies)
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	app := &application{
-		config:        cfg,
-		store:         store,
-		logger:        logger,
-		mailer:        mailer,
-		authenticator: jwtAuthenticator,
+		config:         cfg,
+		db:             db,
+		store:          store,
+		logger:         logger,
+		accessLogger:   accessLogger,
+		mailer:         mailer,
+		authenticator:  authenticator,
+		rateLimiter:    rateLimiter,
+		lifecycle:      lifecycle.New(),
+		healthChecks:   health.NewCache(newHealthChecks(db, mailer), healthCheckTimeout, healthCheckTTL),
+		metrics:        metrics,
+		trustedProxies: trustedProxies,
 	}
 
+	app.lifecycle.Go("janitor", app.runJanitor)
+	app.lifecycle.Go("mail workers", app.runMailWorkers)
+
 	mux := app.mount()
-	logger.Fatal(app.run(mux))
+	if err := app.run(mux); err != nil {
+		// Fatal exits without running the deferred calls
+		db.Close()
+		logger.Fatal(err)
+	}
+}
+
+func newAuthenticator(cfg jwtConfig) (auth.Authenticator, error) {
+	if cfg.signingKeyFile == "" {
+		return auth.NewJWTAuthenticator(cfg.secret, cfg.iss, cfg.iss), nil
+	}
+
+	signingKey, err := auth.LoadKeyFile(cfg.signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
 
+	var verificationKeys []*auth.Key
+	for _, file := range cfg.verificationKeyFiles {
+		key, err := auth.LoadKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return auth.NewKeySetAuthenticator(signingKey, verificationKeys, cfg.iss, cfg.iss)
+}
+
+func newMailer(cfg mailConfig, logger *zap.SugaredLogger) (mailer.Client, error) {
+	switch cfg.backend {
+	case "sendgrid":
+		return mailer.NewSendgrid(cfg.apiKey, cfg.fromEmail), nil
+	case "smtp":
+		return mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.fromEmail), nil
+	case "file":
+		return mailer.NewFileMailer(cfg.dir, cfg.fromEmail)
+	case "log":
+		return mailer.NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q, expected sendgrid, smtp, file or log", cfg.backend)
+	}
+}
+
+// validateIntervals checks the intervals the background jobs wait for, a ticker panicking on one that isn't
+// positive and a poll loop spinning on it
+func validateIntervals(cfg config) error {
+	intervals := []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"JANITOR_INTERVAL", cfg.janitor.interval},
+		{"MAIL_POLL_INTERVAL", cfg.outbox.pollInterval},
+	}
+	for _, i := range intervals {
+		if i.interval <= 0 {
+			return fmt.Errorf("invalid %s %s, expected a positive duration", i.name, i.interval)
+		}
+	}
+
+	return nil
+}
+
+func newRateLimiter(cfg rateLimitConfig, db *sql.DB) (ratelimiter.Limiter, error) {
+	for _, limit := range []ratelimiter.Limit{cfg.auth, cfg.client, cfg.api} {
+		if limit.Requests <= 0 || limit.Window < time.Second {
+			return nil, fmt.Errorf("invalid rate limit %s, expected some requests over a window of at least a second", limit.Policy())
+		}
+	}
+
+	switch cfg.backend {
+	case "memory":
+		return ratelimiter.NewMemory(), nil
+	case "postgres":
+		return ratelimiter.NewPostgres(db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q, expected memory or postgres", cfg.backend)
+	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateIntervals(t *testing.T) {
+	valid := func() config {
+		var cfg config
+		cfg.janitor.interval = time.Hour
+		cfg.outbox.pollInterval = time.Second * 2
+		return cfg
+	}
+
+	if err := validateIntervals(valid()); err != nil {
+		t.Errorf("validateIntervals(valid) = %v, want nil", err)
+	}
+
+	tests := []struct {
+		name string
+		set  func(*config)
+	}{
+		{"zero janitor interval", func(cfg *config) { cfg.janitor.interval = 0 }},
+		{"negative janitor interval", func(cfg *config) { cfg.janitor.interval = -time.Minute }},
+		{"zero poll interval", func(cfg *config) { cfg.outbox.pollInterval = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.set(&cfg)
+			if err := validateIntervals(cfg); err == nil {
+				t.Error("validateIntervals succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "gosocial"
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	storeCallDuration   *prometheus.HistogramVec
+	storeCallErrors     *prometheus.CounterVec
+	mailSends           *prometheus.CounterVec
+}
+
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status.",
+		}, []string{"route", "method", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		storeCallDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_call_duration_seconds",
+			Help:      "Duration of the calls to the store by store and method.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"store", "method"}),
+		storeCallErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "store_call_errors_total",
+			Help:      "Calls to the store that failed by store and method, not found records aside.",
+		}, []string{"store", "method"}),
+		mailSends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mail_sends_total",
+			Help:      "Emails handed over to the mailer by template and result, success or failure.",
+		}, []string{"template", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db, metricsNamespace),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.storeCallDuration,
+		m.storeCallErrors,
+		m.mailSends,
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware counts and times the requests by the pattern of the route they matched, so that
+// /v1/posts/1 and /v1/posts/2 add up
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(status)}
+		m.httpRequests.With(labels).Inc()
+		m.httpRequestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// observeStore times the calls to the store, see store.Instrument
+func (m *metrics) observeStore(ctx context.Context, storeName, method string) (context.Context, func(int, error)) {
+	start := time.Now()
+
+	return ctx, func(_ int, err error) {
+		m.storeCallDuration.WithLabelValues(storeName, method).Observe(time.Since(start).Seconds())
+		if err != nil && !errors.Is(err, store.ErrorNotFound) {
+			m.storeCallErrors.WithLabelValues(storeName, method).Inc()
+		}
+	}
+}
+
+// instrumentedMailer counts the emails sent through the mailer it wraps
+type instrumentedMailer struct {
+	mailer.Client
+	metrics *metrics
+}
+
+func (m *instrumentedMailer) Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error) {
+	status, err := m.Client.Send(ctx, templateFile, locale, username, email, data, isSandbox)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.metrics.mailSends.WithLabelValues(templateFile, result).Inc()
+
+	return status, err
+}
@@ -6,29 +6,46 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/mustaphalimar/go-social/internal/store"
 )
 
+var (
+	errAuthHeaderMissing   = errors.New("Authorization header is missing.")
+	errAuthHeaderMalformed = errors.New("Authorization header is malformed.")
+	errNotAccessToken      = errors.New("Token is not an access token.")
+	errTokenMissingID      = errors.New("Token is missing its id.")
+	errTokenRevoked        = errors.New("Token has been revoked.")
+	errInvalidBasicAuth    = errors.New("Invalid credentials.")
+)
+
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			app.unauthorizedResponse(w, r, errors.New("Authorization header is missing."))
+			app.unauthorizedResponse(w, r, errAuthHeaderMissing)
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedResponse(w, r, errors.New("Authorization header is malformed."))
+			app.unauthorizedResponse(w, r, errAuthHeaderMalformed)
 			return
 		}
 
 		token := parts[1]
 
+		// personal access tokens are opaque, everything else is a JWT
+		if strings.HasPrefix(token, personalTokenPrefix) {
+			app.authenticatePersonalToken(w, r, next, token)
+			return
+		}
+
 		jwtToken, err := app.authenticator.ValidateToken(token)
 		if err != nil {
 			app.unauthorizedResponse(w, r, err)
@@ -42,30 +59,101 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// 2FA challenges and the like are signed by the same authenticator but aren't access tokens
+		if _, ok := claims["typ"]; ok {
+			app.unauthorizedResponse(w, r, errNotAccessToken)
+			return
+		}
+
 		ctx := r.Context()
+
+		jti, _ := claims["jti"].(string)
+		if jti == "" {
+			app.unauthorizedResponse(w, r, errTokenMissingID)
+			return
+		}
+
+		revoked, err := app.store.Tokens.IsAccessTokenRevoked(ctx, jti)
+		if err != nil {
+			app.internalServerResponse(w, r, err)
+			return
+		}
+		if revoked {
+			app.unauthorizedResponse(w, r, errTokenRevoked)
+			return
+		}
+
 		user, err := app.store.Users.GetById(ctx, userId)
 		if err != nil {
 			app.unauthorizedResponse(w, r, err)
 			return
 		}
+
+		// changing the password signs the user out everywhere
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil || iat.Before(user.PasswordChangedAt.Truncate(time.Second)) {
+			app.unauthorizedResponse(w, r, errTokenRevoked)
+			return
+		}
+
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, claimsCtx, claims)
+		ctx = withUserLocale(ctx, user)
+		setLogUser(ctx, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+type claimsKey string
+
+const claimsCtx claimsKey = "claims"
+
+// getClaimsFromContext returns the claims of the access token AuthTokenMiddleware authenticated the request with
+func getClaimsFromContext(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsCtx).(jwt.MapClaims)
+	return claims
+}
+
+// requireScope restricts a route to sessions and to personal access tokens carrying the scope
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scopes, isPersonalToken := getScopesFromContext(r)
+			if isPersonalToken && !slices.Contains(scopes, scope) {
+				app.insufficientScopeResponse(w, r, scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionOnly keeps personal access tokens away from routes that manage the account itself
+func (app *application) sessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, isPersonalToken := getScopesFromContext(r); isPersonalToken {
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// read the auth header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				app.unauthorizedBasicAuthResponse(w, r, errors.New("Authorization header is missing."))
+				app.unauthorizedBasicAuthResponse(w, r, errAuthHeaderMissing)
 				return
 			}
 			// parse it -> get the base64
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Basic" {
-				app.unauthorizedBasicAuthResponse(w, r, errors.New("Authorization header malformed."))
+				app.unauthorizedBasicAuthResponse(w, r, errAuthHeaderMalformed)
 				return
 			}
 
@@ -82,7 +170,7 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 
 			creds := strings.SplitN(string(decoded), ":", 2)
 			if len(creds) != 2 || creds[0] != username || creds[1] != password {
-				app.unauthorizedBasicAuthResponse(w, r, errors.New("Invalid credentials."))
+				app.unauthorizedBasicAuthResponse(w, r, errInvalidBasicAuth)
 				return
 			}
 
@@ -92,11 +180,23 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 }
 
 func (app *application) checkPostsOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(requiredRole, func(r *http.Request) int64 {
+		return getPostFromCtx(r).UserID
+	}, next)
+}
+
+func (app *application) checkCommentsOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return app.checkOwnership(requiredRole, func(r *http.Request) int64 {
+		return getCommentFromCtx(r).UserID
+	}, next)
+}
+
+// checkOwnership lets the owner of a resource through, otherwise the user needs at least the required role
+func (app *application) checkOwnership(requiredRole string, ownerOf func(*http.Request) int64, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := getUserFromContext(r)
-		post := getPostFromCtx(r)
 
-		if post.UserID == user.ID {
+		if ownerOf(r) == user.ID {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -116,6 +216,26 @@ func (app *application) checkPostsOwnership(requiredRole string, next http.Handl
 	})
 }
 
+// requireRole restricts a route to users with at least the given role
+func (app *application) requireRole(requiredRole string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, err := app.checkRolePrecedence(r.Context(), getUserFromContext(r), requiredRole)
+			if err != nil {
+				app.internalServerResponse(w, r, err)
+				return
+			}
+
+			if !allowed {
+				app.forbiddenResponse(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
 	role, err := app.store.Roles.GetByName(ctx, roleName)
 	if err != nil {
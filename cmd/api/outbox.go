@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// runMailWorkers sends the emails of the outbox with a pool of workers, until ctx is done
+func (app *application) runMailWorkers(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < app.config.outbox.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.mailWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (app *application) mailWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := app.store.MailOutbox.Claim(ctx, app.config.outbox.lease)
+		if err == nil {
+			// a claimed job is seen through on shutdown, but not past its lease, after which another
+			// worker may claim it again
+			sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), app.config.outbox.lease)
+			app.sendMailJob(sendCtx, job)
+			cancel()
+			continue
+		}
+
+		if !errors.Is(err, store.ErrorNotFound) && ctx.Err() == nil {
+			app.logger.Errorw("Error while claiming mail job", "error", err)
+		}
+
+		// nothing due, or the database is unavailable, wait a bit
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(app.config.outbox.pollInterval):
+		}
+	}
+}
+
+func (app *application) sendMailJob(ctx context.Context, job *store.MailJob) {
+	ctx, span := tracer.Start(ctx, "outbox.send", trace.WithAttributes(
+		attribute.Int64("mail.job", job.ID),
+		attribute.String("mail.template", job.Template),
+		attribute.Int("mail.attempts", job.Attempts),
+	))
+	defer span.End()
+	logger := app.loggerWithTrace(ctx)
+
+	err := app.deliverMail(ctx, job)
+	if err == nil {
+		if err := app.store.MailOutbox.MarkSent(ctx, job); err != nil {
+			logCompletionError(logger, "Error while marking mail job as sent", err, job)
+		}
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	if errors.Is(err, mailer.ErrPermanent) || job.Attempts >= app.config.outbox.maxAttempts {
+		logger.Errorw("Giving up on mail job", "error", err, "job", job.ID, "attempts", job.Attempts)
+		if err := app.store.MailOutbox.DeadLetter(ctx, job, err.Error()); err != nil {
+			logCompletionError(logger, "Error while dead-lettering mail job", err, job)
+		}
+		return
+	}
+
+	retryAt := time.Now().Add(app.mailBackoff(job.Attempts))
+	logger.Warnw("Mail job failed, retrying", "error", err, "job", job.ID, "attempts", job.Attempts, "retry_at", retryAt)
+	if err := app.store.MailOutbox.Retry(ctx, job, retryAt, err.Error()); err != nil {
+		logCompletionError(logger, "Error while rescheduling mail job", err, job)
+	}
+}
+
+// logCompletionError logs the failure to complete a job, a lease that ran out only being a warning as the
+// job is left to whichever worker claimed it again
+func logCompletionError(logger *zap.SugaredLogger, msg string, err error, job *store.MailJob) {
+	if errors.Is(err, store.ErrorNotFound) {
+		logger.Warnw("Lease of mail job ran out before it was completed", "job", job.ID, "leased_until", job.LeasedUntil)
+		return
+	}
+	logger.Errorw(msg, "error", err, "job", job.ID)
+}
+
+func (app *application) deliverMail(ctx context.Context, job *store.MailJob) error {
+	var data map[string]any
+	if err := json.Unmarshal(job.Data, &data); err != nil {
+		return fmt.Errorf("%w: %v", mailer.ErrPermanent, err)
+	}
+
+	_, span := tracer.Start(ctx, "mailer.Send", trace.WithAttributes(
+		attribute.String("mail.template", job.Template),
+		attribute.String("mail.locale", job.Locale),
+	))
+	defer span.End()
+
+	status, err := app.mailer.Send(ctx, job.Template, job.Locale, job.Username, job.Email, data, job.Sandbox)
+	span.SetAttributes(attribute.Int("mail.status", status))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	app.loggerWithTrace(ctx).Infow("Email sent", "Status Code", status, "job", job.ID, "template", job.Template)
+
+	return nil
+}
+
+// mailBackoff doubles the delay with every attempt up to a maximum, with up to 50% of jitter so that
+// jobs failing together don't retry together
+func (app *application) mailBackoff(attempts int) time.Duration {
+	d := app.config.outbox.backoffBase
+	for i := 1; i < attempts && d < app.config.outbox.backoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, app.config.outbox.backoffMax)
+
+	return d/2 + rand.N(d/2+1)
+}
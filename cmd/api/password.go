@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/mailer"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// forgotPasswordHandler godoc
+//
+//	@Summary		Requests a password reset
+//	@Description	Emails a single-use password reset link. The response is the same whether or not the email belongs to an account.
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ForgotPasswordPayload	true	"Account email"
+//	@Success		202		{string}	string					"Reset email sent if the account exists"
+//	@Failure		400		{object}	error
+//	@Router			/auth/password/forgot [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// whatever happens, the client gets the same answer
+	app.sendPasswordReset(r, payload.Email)
+
+	data := map[string]string{
+		"message": "If an account exists for this email, a password reset link has been sent to it.",
+	}
+	if err := app.jsonResponse(w, http.StatusAccepted, data); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// sendPasswordReset emails a reset link to the account with the given email, if there is one.
+// Errors are only logged, the caller must not tell whether the email exists.
+func (app *application) sendPasswordReset(r *http.Request, email string) {
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, store.ErrorNotFound) {
+			app.loggerFrom(ctx).Errorw("Error while looking up user for password reset", "error", err)
+		}
+		return
+	}
+
+	plainTextToken := uuid.New().String()
+
+	vars := struct {
+		Username  string
+		ResetURL  string
+		ExpiresIn string
+	}{
+		Username:  user.Username,
+		ResetURL:  fmt.Sprintf("%s/reset-password/%s", app.config.clientURL, plainTextToken),
+		ExpiresIn: i18n.Duration(mailLocale(r, user), app.config.mail.resetExp),
+	}
+	isProdEnv := app.config.env == "production"
+
+	mail, err := store.NewMailJob(mailer.PasswordResetTemplate, mailLocale(r, user), user.Username, user.Email, vars, !isProdEnv)
+	if err != nil {
+		app.loggerFrom(ctx).Errorw("Error while preparing the password reset email", "error", err)
+		return
+	}
+
+	if err := app.store.Users.CreatePasswordReset(ctx, user.ID, hashToken(plainTextToken), app.config.mail.resetExp, mail); err != nil {
+		app.loggerFrom(ctx).Errorw("Error while creating password reset", "error", err)
+	}
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required,max=255"`
+	Password string `json:"password" validate:"required,min=3,max=72"`
+}
+
+// resetPasswordHandler godoc
+//
+//	@Summary		Resets a password
+//	@Description	Sets a new password with a token from a password reset email, and signs the user out everywhere
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResetPasswordPayload	true	"Reset token and new password"
+//	@Success		204		{string}	string					"Password reset"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error	"Invalid or expired token"
+//	@Failure		500		{object}	error
+//	@Router			/auth/password/reset [post]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.ResetPassword(r.Context(), hashToken(payload.Token), payload.Password); err != nil {
+		switch err {
+		case store.ErrorNotFound:
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-playground/validator/v10"
+	"github.com/mustaphalimar/go-social/internal/i18n"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+// problem is an RFC 7807 problem details object, the body of every error response
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError is what is wrong with one field of the request
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// problemType is the URI identifying the type of the problems with the given code
+func problemType(code string) string {
+	return "urn:go-social:problem:" + strings.ToLower(strings.ReplaceAll(code, "_", "-"))
+}
+
+// writeProblem completes p with the fields derived from the request and its status, and writes it
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, p *problem) {
+	locale := getLocaleFromContext(r)
+
+	p.Type = problemType(p.Code)
+	p.Title = statusTitle(locale, p.Status)
+	p.Instance = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Language", locale)
+	writeProblemJSON(w, p)
+}
+
+// badRequestProblem turns the errors of the JSON decoder, the validator, strconv and ours into a problem
+// without their raw messages, errors without a code getting the message of BAD_REQUEST_ERROR
+func badRequestProblem(locale string, err error) *problem {
+	var (
+		validationErrors validator.ValidationErrors
+		syntaxError      *json.SyntaxError
+		typeError        *json.UnmarshalTypeError
+		maxBytesError    *http.MaxBytesError
+		numError         *strconv.NumError
+		paramError       *store.ParamError
+	)
+
+	switch {
+	case errors.As(err, &validationErrors):
+		p := invalidFieldsProblem(locale)
+		for _, fe := range validationErrors {
+			p.Errors = append(p.Errors, validationFieldError(locale, fe))
+		}
+		return p
+	case errors.As(err, &typeError):
+		p := invalidFieldsProblem(locale)
+		p.Errors = append(p.Errors, fieldError{
+			Field:   typeError.Field,
+			Code:    "INVALID_TYPE",
+			Message: i18n.T(locale, "INVALID_TYPE", i18n.T(locale, jsonTypeName(typeError.Type))),
+		})
+		return p
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		p := invalidFieldsProblem(locale)
+		p.Errors = append(p.Errors, fieldError{
+			Field:   strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+			Code:    "UNKNOWN_FIELD",
+			Message: i18n.T(locale, "UNKNOWN_FIELD"),
+		})
+		return p
+	case errors.As(err, &syntaxError):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "MALFORMED_JSON",
+			Detail: i18n.T(locale, "MALFORMED_JSON_AT", syntaxError.Offset),
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "MALFORMED_JSON",
+			Detail: i18n.T(locale, "MALFORMED_JSON"),
+		}
+	case errors.Is(err, io.EOF):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "EMPTY_BODY",
+			Detail: i18n.T(locale, "EMPTY_BODY"),
+		}
+	case errors.As(err, &maxBytesError):
+		return &problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Code:   "BODY_TOO_LARGE",
+			Detail: i18n.T(locale, "BODY_TOO_LARGE", maxBytesError.Limit),
+		}
+	case errors.As(err, &numError):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "INVALID_PARAMETER",
+			Detail: i18n.T(locale, "INVALID_PARAMETER", numError.Num),
+		}
+	case errors.As(err, &paramError):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "INVALID_QUERY_PARAMETER",
+			Detail: i18n.T(locale, "INVALID_QUERY_PARAMETER", paramError.Name, paramError.Value),
+		}
+	case errors.Is(err, store.ErrInvalidDepth):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "INVALID_DEPTH",
+			Detail: i18n.T(locale, "INVALID_DEPTH", store.MaxCommentDepth),
+		}
+	case errors.Is(err, errInvalidLocale):
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   "INVALID_LOCALE",
+			Detail: i18n.T(locale, "INVALID_LOCALE", strings.Join(i18n.Supported, ", ")),
+		}
+	default:
+		code := errorCode(err, "BAD_REQUEST_ERROR")
+		return &problem{
+			Status: http.StatusBadRequest,
+			Code:   code,
+			Detail: i18n.T(locale, code),
+		}
+	}
+}
+
+func invalidFieldsProblem(locale string) *problem {
+	return &problem{
+		Status: http.StatusBadRequest,
+		Code:   "VALIDATION_ERROR",
+		Detail: i18n.T(locale, "VALIDATION_ERROR"),
+	}
+}
+
+// validationFieldError describes a failed validation tag, the field being named after its JSON tag.
+// Payloads are flat, embedded structs included, so the name of the field is its path.
+func validationFieldError(locale string, fe validator.FieldError) fieldError {
+	// of alternatives such as http_url|len=0, the first one is the rule being broken
+	tag, _, _ := strings.Cut(fe.Tag(), "|")
+	param := fe.Param()
+
+	var id string
+	switch tag {
+	case "required", "email", "url", "http_url", "numeric", "gt":
+		id = "FIELD_" + strings.ToUpper(tag)
+	case "required_without":
+		id = "FIELD_REQUIRED_WITHOUT"
+		param = toSnakeCase(param)
+	case "oneof":
+		id = "FIELD_ONEOF"
+		param = strings.ReplaceAll(param, " ", ", ")
+	case "locale":
+		id = "FIELD_ONEOF"
+		param = strings.Join(i18n.Supported, ", ")
+	case "len":
+		id = "FIELD_LEN" + sizeSuffix(fe.Kind())
+	case "min", "gte":
+		id = "FIELD_MIN" + sizeSuffix(fe.Kind())
+	case "max", "lte":
+		id = "FIELD_MAX" + sizeSuffix(fe.Kind())
+	default:
+		id = "FIELD_INVALID"
+	}
+
+	message := i18n.T(locale, id)
+	if strings.Contains(message, "%s") {
+		message = fmt.Sprintf(message, param)
+	}
+
+	return fieldError{
+		Field:   fe.Field(),
+		Code:    strings.ToUpper(tag),
+		Message: message,
+	}
+}
+
+// sizeSuffix tells the messages apart by how the size of a field of the given kind is measured
+func sizeSuffix(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "_STRING"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return "_ITEMS"
+	default:
+		return "_NUMBER"
+	}
+}
+
+// statusTitle is the title of the problems of the given status, in the locale
+func statusTitle(locale string, status int) string {
+	if title, ok := i18n.Message(locale, "STATUS_"+strconv.Itoa(status)); ok {
+		return title
+	}
+	return http.StatusText(status)
+}
+
+// jsonTypeName is the id of the name of the JSON type a value of type t is decoded from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "TYPE_STRING"
+	case reflect.Bool:
+		return "TYPE_BOOLEAN"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "TYPE_NUMBER"
+	case reflect.Slice, reflect.Array:
+		return "TYPE_ARRAY"
+	default:
+		return "TYPE_OBJECT"
+	}
+}
+
+// toSnakeCase turns the Go name of a field, as found in validation params, into its JSON name
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mustaphalimar/go-social/internal/ratelimiter"
+)
+
+// rateLimit limits the requests of the route group named policy, by user once authenticated and by IP before
+func (app *application) rateLimit(policy string, limit ratelimiter.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.config.rateLimit.enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := fmt.Sprintf("%s:ip:%s", policy, clientIP(r))
+			if user := getUserFromContext(r); user != nil {
+				key = fmt.Sprintf("%s:user:%d", policy, user.ID)
+			}
+
+			res, err := app.rateLimiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// an unavailable limiter shouldn't take the API down with it
+				app.loggerFrom(r.Context()).Errorw("Error while rate limiting", "error", err, "key", key)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", limit.Policy())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+			if !res.Allowed {
+				app.tooManyRequestsResponse(w, r, res.RetryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+// searchHandler godoc
+//
+//	@Summary		Search posts, comments and users
+//	@Description	Full-text search over posts and comments, and username search over users, ordered by relevance.
+//	@Description	Supports quoted phrases, OR and -exclusions. Titles and snippets are escaped HTML with the matched terms wrapped in <mark></mark>.
+//	@Tags			search
+//	@Produce		json
+//	@Param			q		query		string	true	"Search terms"
+//	@Param			limit	query		int		false	"Limit the number of results"
+//	@Param			offset	query		int		false	"Offset for pagination"
+//	@Success		200		{array}		store.SearchResult
+//	@Failure		400		{object}	error	"Invalid query parameters"
+//	@Failure		500		{object}	error	"Internal server error"
+//	@Security		ApiKeyAuth
+//	@Router			/search [get]
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	sq := store.SearchQuery{
+		Limit:  20,
+		Offset: 0,
+	}
+
+	sq, err := sq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(sq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	results, err := app.store.Search.Search(r.Context(), sq)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, results); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
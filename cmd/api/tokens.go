@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/mustaphalimar/go-social/internal/store"
+)
+
+var errInvalidToken = errors.New("Token is invalid or has expired.")
+
+// personalTokenPrefix tells personal access tokens apart from JWTs, and makes them easy to spot when leaked
+const personalTokenPrefix = "gsp_"
+
+// scopes a personal access token can be granted
+const (
+	scopePostsRead     = "posts:read"
+	scopePostsWrite    = "posts:write"
+	scopeCommentsRead  = "comments:read"
+	scopeCommentsWrite = "comments:write"
+	scopeFeedRead      = "feed:read"
+	scopeUsersRead     = "users:read"
+	scopeUsersWrite    = "users:write"
+	scopeSearchRead    = "search:read"
+)
+
+type scopesKey string
+
+const scopesCtx scopesKey = "scopes"
+
+type CreatePersonalTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=posts:read posts:write comments:read comments:write feed:read users:read users:write search:read"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,gte=1,lte=365"`
+}
+
+type PersonalTokenWithSecret struct {
+	*store.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// createPersonalTokenHandler godoc
+//
+//	@Summary		Creates a personal access token
+//	@Description	Creates a named, scoped and expiring token for API clients. The token is only ever shown in this response.
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreatePersonalTokenPayload	true	"Token name, scopes and lifetime (30 days by default)"
+//	@Success		201		{object}	PersonalTokenWithSecret
+//	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [post]
+func (app *application) createPersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreatePersonalTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.ExpiresInDays == 0 {
+		payload.ExpiresInDays = 30
+	}
+
+	user := getUserFromContext(r)
+
+	plainTextToken := personalTokenPrefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+	token := &store.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      payload.Name,
+		Token:     hashToken(plainTextToken),
+		Scopes:    payload.Scopes,
+		ExpiresAt: time.Now().Add(time.Hour * 24 * time.Duration(payload.ExpiresInDays)),
+	}
+
+	if err := app.store.PersonalTokens.Create(r.Context(), token); err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, PersonalTokenWithSecret{token, plainTextToken}); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// listPersonalTokensHandler godoc
+//
+//	@Summary		Lists personal access tokens
+//	@Description	Lists the personal access tokens of the authenticated user
+//	@Tags			tokens
+//	@Produce		json
+//	@Success		200	{array}		store.PersonalAccessToken
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [get]
+func (app *application) listPersonalTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	tokens, err := app.store.PersonalTokens.GetByUserId(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// deletePersonalTokenHandler godoc
+//
+//	@Summary		Revokes a personal access token
+//	@Description	Deletes one of the personal access tokens of the authenticated user
+//	@Tags			tokens
+//	@Produce		json
+//	@Param			tokenId	path	int	true	"Token ID"
+//	@Success		204		"No Content"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens/{tokenId} [delete]
+func (app *application) deletePersonalTokenHandler(w http.ResponseWriter, r *http.Request) {
+	tokenId, err := strconv.ParseInt(chi.URLParam(r, "tokenId"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.PersonalTokens.Delete(r.Context(), user.ID, tokenId); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticatePersonalToken is the AuthTokenMiddleware counterpart for personal access tokens
+func (app *application) authenticatePersonalToken(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	ctx := r.Context()
+
+	pat, err := app.store.PersonalTokens.Authenticate(ctx, hashToken(token))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.unauthorizedResponse(w, r, errInvalidToken)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetById(ctx, pat.UserID)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	ctx = context.WithValue(ctx, userCtx, user)
+	ctx = context.WithValue(ctx, scopesCtx, pat.Scopes)
+	ctx = withUserLocale(ctx, user)
+	setLogUser(ctx, user)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// getScopesFromContext returns the scopes of the personal access token the request was authenticated with,
+// the second value being false for regular sessions, which aren't restricted by scopes.
+func getScopesFromContext(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value(scopesCtx).([]string)
+	return scopes, ok
+}
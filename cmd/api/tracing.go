@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const tracerName = "github.com/mustaphalimar/go-social"
+
+var tracer = otel.Tracer(tracerName)
+
+// newTracerProvider sets up the exporter of the spans, returning nil when tracing is off. The OTLP exporter
+// and the sampler are configured with the standard OTEL_EXPORTER_OTLP_* and OTEL_TRACES_SAMPLER variables.
+func newTracerProvider(ctx context.Context, cfg tracingConfig, env string) (*sdktrace.TracerProvider, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch cfg.exporter {
+	case "none":
+		return nil, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER %q, expected otlp, stdout or none", cfg.exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.serviceName),
+		semconv.ServiceVersion(version),
+		semconv.DeploymentEnvironmentName(env),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return tp, nil
+}
+
+// tracingMiddleware starts a span for every request, continuing the trace of the caller when it sends
+// a traceparent header
+func (app *application) tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(clientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// the route is only known once the request went through the router
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// traceStore starts a span for every call to the store, the SQL statements it runs being spans of their own
+func traceStore(ctx context.Context, storeName, method string) (context.Context, func(int, error)) {
+	ctx, span := tracer.Start(ctx, storeName+"."+method,
+		trace.WithAttributes(
+			attribute.String("store.name", storeName),
+			attribute.String("store.method", method),
+		),
+	)
+
+	return ctx, func(rows int, err error) {
+		if rows >= 0 {
+			span.SetAttributes(attribute.Int("store.rows", rows))
+		}
+		if err != nil && !errors.Is(err, store.ErrorNotFound) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// loggerWithTrace adds the ids of the span of ctx to the log lines, so that they can be found from the trace
+func (app *application) loggerWithTrace(ctx context.Context) *zap.SugaredLogger {
+	fields := traceFields(ctx)
+	if fields == nil {
+		return app.logger
+	}
+
+	return app.logger.With(fields...)
+}
+
+// traceFields are the ids of the span of ctx as log fields, none if it has no span
+func traceFields(ctx context.Context) []any {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+
+	return []any{"trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String()}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mustaphalimar/go-social/internal/store"
+	"github.com/mustaphalimar/go-social/internal/totp"
+)
+
+const (
+	totpIssuer = "GoSocial"
+	// twoFactorChallengeType is the typ claim of challenge tokens, which AuthTokenMiddleware refuses
+	twoFactorChallengeType = "2fa_challenge"
+	twoFactorChallengeExp  = time.Minute * 5
+	recoveryCodesCount     = 10
+)
+
+var (
+	errInvalidSecondFactor   = errors.New("Invalid two-factor code.")
+	errNotTwoFactorChallenge = errors.New("Token is not a two-factor challenge.")
+	errTwoFactorEnabled      = errors.New("Two-factor authentication is already enabled")
+	errTwoFactorNotEnabled   = errors.New("Two-factor authentication is not enabled")
+	errTwoFactorNotStarted   = errors.New("Two-factor enrolment has not been started")
+	errTwoFactorChanged      = errors.New("Two-factor enrolment was completed or restarted in the meantime")
+)
+
+type TwoFactorEnrolment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// enableTwoFactorHandler godoc
+//
+//	@Summary		Starts 2FA enrolment
+//	@Description	Generates a TOTP secret to add to an authenticator app. 2FA is only enabled once a first code is confirmed.
+//	@Tags			two-factor
+//	@Produce		json
+//	@Success		201	{object}	TwoFactorEnrolment
+//	@Failure		409	{object}	error	"2FA is already enabled"
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa [post]
+func (app *application) enableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.SetPendingSecret(r.Context(), user.ID, secret); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.conflictResponse(w, r, errTwoFactorEnabled)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	enrolment := TwoFactorEnrolment{
+		Secret:     secret,
+		OTPAuthURI: totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrolment); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type TwoFactorCodePayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// confirmTwoFactorHandler godoc
+//
+//	@Summary		Confirms 2FA enrolment
+//	@Description	Enables 2FA with a first code from the authenticator app, and returns one-time recovery codes. They are only ever shown in this response.
+//	@Tags			two-factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorCodePayload	true	"TOTP code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error	"Invalid code"
+//	@Failure		409		{object}	error	"2FA is already enabled, or enrolment was restarted meanwhile"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/confirm [post]
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TwoFactorCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if twoFactor.Enabled {
+		app.conflictResponse(w, r, errTwoFactorEnabled)
+		return
+	}
+
+	if twoFactor.Secret == "" {
+		app.badRequestResponse(w, r, errTwoFactorNotStarted)
+		return
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, payload.Code, time.Now())
+	if !ok {
+		app.unauthorizedResponse(w, r, errInvalidSecondFactor)
+		return
+	}
+
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(ctx, user.ID, twoFactor.Secret, step, hashedCodes); err != nil {
+		switch err {
+		case store.ErrConflict:
+			app.conflictResponse(w, r, errTwoFactorChanged)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{codes}); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+type SecondFactorPayload struct {
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code,omitempty,max=20"`
+}
+
+// disableTwoFactorHandler godoc
+//
+//	@Summary		Disables 2FA
+//	@Description	Disables 2FA, given a current code or a recovery code
+//	@Tags			two-factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		SecondFactorPayload	true	"TOTP code or recovery code"
+//	@Success		204		{string}	string				"2FA disabled"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error	"Invalid code"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/disable [post]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SecondFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if !twoFactor.Enabled {
+		app.badRequestResponse(w, r, errTwoFactorNotEnabled)
+		return
+	}
+
+	if err := app.checkSecondFactor(ctx, user.ID, twoFactor, payload); err != nil {
+		switch err {
+		case errInvalidSecondFactor:
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+type TwoFactorTokenPayload struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	SecondFactorPayload
+}
+
+// verifyTwoFactorHandler godoc
+//
+//	@Summary		Completes a 2FA login
+//	@Description	Exchanges the challenge token returned by /auth/token and a TOTP or recovery code for an access token and a refresh token
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorTokenPayload	true	"Challenge token and TOTP code or recovery code"
+//	@Success		201		{object}	TokenPair				"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		429		{object}	error	"Too many failed attempts"
+//	@Failure		500		{object}	error
+//	@Router			/auth/token/2fa [post]
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TwoFactorTokenPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userId, err := app.parseTwoFactorChallenge(payload.ChallengeToken)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.Users.GetById(ctx, userId)
+	if err != nil {
+		app.unauthorizedResponse(w, r, err)
+		return
+	}
+
+	// guessing codes counts towards the same lockout as guessing passwords
+	retryAfter, err := app.checkLoginLockout(ctx, user.Email, clientIP(r))
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.recordLogin(r, user, user.Email, store.LoginLockedOut)
+		app.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, userId)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+
+	if err := app.checkSecondFactor(ctx, userId, twoFactor, payload.SecondFactorPayload); err != nil {
+		switch err {
+		case errInvalidSecondFactor:
+			app.recordLogin(r, user, user.Email, store.LoginInvalidSecondFactor)
+			app.unauthorizedResponse(w, r, err)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	tokens, err := app.startSession(ctx, userId)
+	if err != nil {
+		app.internalServerResponse(w, r, err)
+		return
+	}
+	app.recordLogin(r, user, user.Email, store.LoginSuccess)
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// checkSecondFactor accepts a TOTP code that hasn't been used yet, or burns an unused recovery code
+func (app *application) checkSecondFactor(ctx context.Context, userId int64, twoFactor *store.TwoFactor, payload SecondFactorPayload) error {
+	if !twoFactor.Enabled {
+		return errInvalidSecondFactor
+	}
+
+	if payload.RecoveryCode != "" {
+		err := app.store.TwoFactor.UseRecoveryCode(ctx, userId, hashToken(normalizeRecoveryCode(payload.RecoveryCode)))
+		if err == store.ErrorNotFound {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+
+	step, ok := totp.ValidateAfter(twoFactor.Secret, payload.Code, time.Now(), twoFactor.LastStep)
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	err := app.store.TwoFactor.UseStep(ctx, userId, step)
+	if err == store.ErrTokenReused {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+func (app *application) newTwoFactorChallenge(userId int64) (*TwoFactorChallenge, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": userId,
+		"typ": twoFactorChallengeType,
+		"exp": now.Add(twoFactorChallengeExp).Unix(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"iss": app.config.auth.jwt.iss,
+		"aud": app.config.auth.jwt.iss,
+	}
+
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorChallenge{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresIn:         int64(twoFactorChallengeExp.Seconds()),
+	}, nil
+}
+
+func (app *application) parseTwoFactorChallenge(token string) (int64, error) {
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return 0, err
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != twoFactorChallengeType {
+		return 0, errNotTwoFactorChallenge
+	}
+
+	return strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+}
+
+// generateRecoveryCodes returns the codes to show the user along with their hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+
+	for i := range codes {
+		raw := make([]byte, 6)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes with or without the dash, in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
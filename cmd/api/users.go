@@ -14,6 +14,8 @@ type userKey string
 
 const userCtx userKey = "user"
 
+var errProfileModified = errors.New("The profile was modified in the meantime, fetch it again and retry")
+
 // GetUser godoc
 //
 //	@Summary		Fetches a user profile
@@ -36,6 +38,109 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getMeHandler godoc
+//
+//	@Summary		Fetches the authenticated user
+//	@Description	Fetches the profile of the authenticated user, including its current version
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{object}	store.User
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [get]
+func (app *application) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
+// UpdateProfilePayload only changes the fields it holds, an empty string clearing a field but the username
+type UpdateProfilePayload struct {
+	Username    *string `json:"username" validate:"omitnil,min=1,max=100"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=500"`
+	Location    *string `json:"location" validate:"omitempty,max=100"`
+	Website     *string `json:"website" validate:"omitempty,max=255,http_url|len=0"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,max=255,http_url|len=0"`
+	// Locale is the language of emails and error messages, empty to follow Accept-Language
+	Locale *string `json:"locale" validate:"omitempty,locale"`
+	// Version, when given, must be the version the client read, so that it doesn't overwrite changes it hasn't seen
+	Version *int `json:"version"`
+}
+
+// updateMeHandler godoc
+//
+//	@Summary		Updates the authenticated user
+//	@Description	Updates the username and profile of the authenticated user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateProfilePayload	true	"Fields to update"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		409		{object}	error	"Username taken or profile modified concurrently"
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [patch]
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Username != nil {
+		user.Username = *payload.Username
+	}
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+	if payload.Location != nil {
+		user.Location = *payload.Location
+	}
+	if payload.Website != nil {
+		user.Website = *payload.Website
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+	if payload.Locale != nil {
+		user.Locale = *payload.Locale
+	}
+	if payload.Version != nil {
+		user.Version = *payload.Version
+	}
+
+	if err := app.store.Users.Update(r.Context(), user); err != nil {
+		switch err {
+		case store.ErrDuplicateUsername:
+			app.conflictResponse(w, r, err)
+		case store.ErrorNotFound:
+			app.conflictResponse(w, r, errProfileModified)
+		default:
+			app.internalServerResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerResponse(w, r, err)
+	}
+}
+
 type FollowUser struct {
 	UserId int64 `json:"user_id"`
 }
@@ -135,7 +240,7 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 	ctx := r.Context()
 
-	if err := app.store.Followers.Unfollow(ctx, followerUser.ID, unfollowedUserId); err != nil {
+	if _, err := app.store.Followers.Unfollow(ctx, followerUser.ID, unfollowedUserId); err != nil {
 		app.internalServerResponse(w, r, err)
 		return
 	}
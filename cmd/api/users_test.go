@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestUpdateProfilePayloadUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username *string
+		valid    bool
+	}{
+		{"absent", nil, true},
+		{"given", ptr("jane"), true},
+		{"empty", ptr(""), false},
+		{"too long", ptr(string(make([]byte, 101))), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate.Struct(UpdateProfilePayload{Username: tt.username})
+			if valid := err == nil; valid != tt.valid {
+				t.Errorf("valid = %t, want %t (%v)", valid, tt.valid, err)
+			}
+		})
+	}
+}
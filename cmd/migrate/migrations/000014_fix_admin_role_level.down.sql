@@ -0,0 +1 @@
+UPDATE roles SET level = 1 WHERE name = 'admin';
@@ -0,0 +1,2 @@
+-- admins were created with the level of regular users, letting any user through admin checks
+UPDATE roles SET level = 3 WHERE name = 'admin';
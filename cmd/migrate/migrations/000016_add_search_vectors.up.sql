@@ -0,0 +1,16 @@
+ALTER TABLE posts
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english'::regconfig, coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english'::regconfig, coalesce(content, '')), 'B')
+) STORED;
+
+ALTER TABLE comments
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    to_tsvector('english'::regconfig, coalesce(content, ''))
+) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_posts_search_vector ON posts USING gin (search_vector);
+
+CREATE INDEX IF NOT EXISTS idx_comments_search_vector ON comments USING gin (search_vector);
+
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (username gin_trgm_ops);
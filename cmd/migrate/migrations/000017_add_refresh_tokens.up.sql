@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id bigserial PRIMARY KEY,
+    token bytea UNIQUE NOT NULL,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    family_id uuid NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL,
+    revoked_at timestamp(0) with time zone,
+    replaced_by bigint REFERENCES refresh_tokens (id) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti uuid PRIMARY KEY,
+    expires_at timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,19 @@
+ALTER TABLE users
+ADD COLUMN totp_secret TEXT;
+
+ALTER TABLE users
+ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- last accepted time step, so that a code can't be used twice
+ALTER TABLE users
+ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code bytea NOT NULL,
+    used_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
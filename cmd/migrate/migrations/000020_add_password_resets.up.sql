@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets (user_id);
+
+-- access tokens issued before this are no longer accepted
+ALTER TABLE users
+ADD COLUMN password_changed_at timestamp with time zone NOT NULL DEFAULT NOW ();
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS login_events (
+    id bigserial PRIMARY KEY,
+    user_id bigint REFERENCES users (id) ON DELETE CASCADE,
+    email citext NOT NULL,
+    ip varchar(64) NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    outcome varchar(32) NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_email_created_at ON login_events (email, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_ip_created_at ON login_events (ip, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id_created_at ON login_events (user_id, created_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS email_changes (
+    token bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    new_email citext NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_changes_user_id ON email_changes (user_id);
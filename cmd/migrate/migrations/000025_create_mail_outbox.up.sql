@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS mail_outbox (
+    id bigserial PRIMARY KEY,
+    template varchar(255) NOT NULL,
+    username varchar(255) NOT NULL,
+    email citext NOT NULL,
+    data jsonb NOT NULL DEFAULT '{}',
+    sandbox boolean NOT NULL DEFAULT false,
+    -- pending jobs are (re)tried from run_at, sent and dead ones are kept for inspection
+    status varchar(16) NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    last_error text NOT NULL DEFAULT '',
+    run_at timestamp with time zone NOT NULL DEFAULT NOW (),
+    sent_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW ()
+);
+
+CREATE INDEX IF NOT EXISTS idx_mail_outbox_pending_run_at ON mail_outbox (run_at)
+WHERE
+    status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_mail_outbox_sent_at ON mail_outbox (sent_at)
+WHERE
+    status = 'sent';
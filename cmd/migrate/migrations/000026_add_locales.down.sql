@@ -0,0 +1,5 @@
+ALTER TABLE mail_outbox
+DROP COLUMN locale;
+
+ALTER TABLE users
+DROP COLUMN locale;
@@ -0,0 +1,6 @@
+-- an empty locale means the user has no preference, and gets the one of its requests
+ALTER TABLE users
+ADD COLUMN locale varchar(16) NOT NULL DEFAULT '';
+
+ALTER TABLE mail_outbox
+ADD COLUMN locale varchar(16) NOT NULL DEFAULT 'en';
@@ -0,0 +1,11 @@
+-- counters are cheap to lose on a crash, so the table skips the WAL
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limits (
+    key varchar(255) NOT NULL,
+    window_start timestamp with time zone NOT NULL,
+    count int NOT NULL DEFAULT 0,
+    -- when the window stops weighing on the next one
+    expires_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limits_expires_at ON rate_limits (expires_at);
@@ -6,3 +6,8 @@ type Authenticator interface {
 	GenerateToken(claims jwt.Claims) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
 }
+
+// KeyPublisher is implemented by authenticators whose verification keys can be shared publicly
+type KeyPublisher interface {
+	JWKS() JWKSet
+}
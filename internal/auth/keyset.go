@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeySetAuthenticator signs tokens with one private key and verifies them against a set of keys,
+// picked by the kid header. Rotating is a matter of adding the new key to the set, switching
+// signing to it once every verifier has picked it up, and dropping the old one after the
+// last token it signed has expired.
+type KeySetAuthenticator struct {
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+	aud     string
+	iss     string
+}
+
+// NewKeySetAuthenticator verifies with the signing key and any additional verification keys
+func NewKeySetAuthenticator(signing *Key, verification []*Key, aud, iss string) (*KeySetAuthenticator, error) {
+	if !signing.CanSign() {
+		return nil, fmt.Errorf("Key %s is a public key and cannot sign tokens", signing.ID)
+	}
+
+	ka := &KeySetAuthenticator{
+		signing: signing,
+		keys:    map[string]*Key{},
+		aud:     aud,
+		iss:     iss,
+	}
+
+	for _, k := range append([]*Key{signing}, verification...) {
+		if _, ok := ka.keys[k.ID]; ok {
+			continue
+		}
+		ka.keys[k.ID] = k
+		ka.order = append(ka.order, k.ID)
+	}
+
+	return ka, nil
+}
+
+func (ka *KeySetAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ka.signing.Method, claims)
+	token.Header["kid"] = ka.signing.ID
+
+	return token.SignedString(ka.signing.private)
+}
+
+func (ka *KeySetAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("Token is missing the kid header")
+		}
+
+		key, ok := ka.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("Unknown signing key %q", kid)
+		}
+
+		// a key only ever verifies the algorithm it was made for
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("Unexpected signing method %v", t.Header["alg"])
+		}
+
+		return key.public, nil
+	},
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(ka.aud),
+		jwt.WithIssuer(ka.iss),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	)
+}
+
+// JWKS publishes the public half of every verification key
+func (ka *KeySetAuthenticator) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(ka.order))}
+	for _, kid := range ka.order {
+		set.Keys = append(set.Keys, ka.keys[kid].JWK())
+	}
+	return set
+}
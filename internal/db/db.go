@@ -4,10 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 )
 
+// New opens a pool of connections whose statements are traced, as spans of the context they run with
 func New(addr string, maxOpenConns, maxIdleConns int, maxIdleTime string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", addr)
+	db, err := otelsql.Open("postgres", addr,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			// a span per rows.Next is noise, the number of rows being recorded on the spans of the store
+			OmitRows: true,
+		}),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -29,3 +40,11 @@ func New(addr string, maxOpenConns, maxIdleConns int, maxIdleTime string) (*sql.
 	}
 	return db, nil
 }
+
+// SchemaVersion is the version of the last migration applied by golang-migrate, dirty when it failed halfway
+func SchemaVersion(ctx context.Context, db *sql.DB) (version int, dirty bool, err error) {
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	err = db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	return version, dirty, err
+}
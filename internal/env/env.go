@@ -3,6 +3,8 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func GetString(key, fallback string) string {
@@ -26,3 +28,50 @@ func GetInt(key string, fallback int) int {
 
 	return intVal
 }
+
+// GetDuration reads a duration such as "90s" or "24h"
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fallback
+	}
+
+	return d
+}
+
+// GetStrings reads a comma separated list, skipping empty entries
+func GetStrings(key string, fallback []string) []string {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var vals []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+
+	return vals
+}
+
+// GetBool reads a boolean such as "true", "false", "1" or "0"
+func GetBool(key string, fallback bool) bool {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return fallback
+	}
+
+	return b
+}
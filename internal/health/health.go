@@ -0,0 +1,137 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK      = "OK"
+	StatusFailing = "FAILING"
+)
+
+// Checker tells whether a dependency can be used
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a named checker. An optional dependency failing degrades the service without making it unready,
+// such as the mailer whose emails wait in the outbox meanwhile.
+type Check struct {
+	Name     string
+	Checker  Checker
+	Optional bool
+}
+
+type Result struct {
+	Name     string
+	Status   string
+	Optional bool
+	Latency  time.Duration
+	Error    string
+}
+
+type Report struct {
+	Results []Result
+}
+
+// Ready is false when a required check failed
+func (r Report) Ready() bool {
+	for _, res := range r.Results {
+		if res.Status != StatusOK && !res.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+// Degraded is true when an optional check failed
+func (r Report) Degraded() bool {
+	for _, res := range r.Results {
+		if res.Status != StatusOK && res.Optional {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the checks concurrently, each one failing if it takes longer than timeout
+func Run(ctx context.Context, checks []Check, timeout time.Duration) Report {
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, check, timeout)
+		}()
+	}
+	wg.Wait()
+
+	return Report{Results: results}
+}
+
+func run(ctx context.Context, check Check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Checker.Check(ctx)
+
+	res := Result{
+		Name:     check.Name,
+		Status:   StatusOK,
+		Optional: check.Optional,
+		Latency:  time.Since(start),
+	}
+	if err != nil {
+		res.Status = StatusFailing
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// Cache runs the checks at most once every ttl, serving the last report in between, so that probing the
+// service as often as one wants doesn't hit its dependencies as often
+type Cache struct {
+	checks  []Check
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	report  Report
+	expires time.Time
+}
+
+func NewCache(checks []Check, timeout, ttl time.Duration) *Cache {
+	return &Cache{
+		checks:  checks,
+		timeout: timeout,
+		ttl:     ttl,
+	}
+}
+
+// Run returns the last report if it is fresh, or runs the checks. Concurrent callers wait for the same run,
+// which outlives the cancellation of ctx so that a client going away doesn't cache failures.
+func (c *Cache) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Now().Before(c.expires) {
+		return c.report
+	}
+
+	c.report = Run(context.WithoutCancel(ctx), c.checks, c.timeout)
+	c.expires = time.Now().Add(c.ttl)
+
+	return c.report
+}
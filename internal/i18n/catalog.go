@@ -0,0 +1,179 @@
+package i18n
+
+// catalog maps the ids of the messages to their text, by locale. The ids are the error codes clients get,
+// FIELD_ followed by the failed validation rule for the messages of invalid fields, and STATUS_ followed by
+// the HTTP status for the titles of problems.
+var catalog = map[string]map[string]string{
+	"en": {
+		// errors
+		"INTERNAL_SERVER_ERROR":          "The server encountered a problem while processing your request.",
+		"CONFLICT_ERROR":                 "The request conflicts with the current state of the resource.",
+		"UNAUTHORIZED_ERROR":             "The token is invalid or has expired.",
+		"UNAUTHORIZED_BASIC_AUTH_ERROR":  "Invalid credentials.",
+		"FORBIDDEN_ERROR":                "This action is forbidden.",
+		"TOO_MANY_REQUESTS_ERROR":        "Too many attempts, please try again later.",
+		"NOT_FOUND_ERROR":                "Resource not found.",
+		"INSUFFICIENT_SCOPE_ERROR":       "This token is missing the %s scope.",
+		"AUTH_HEADER_MISSING":            "Authorization header is missing.",
+		"AUTH_HEADER_MALFORMED":          "Authorization header is malformed.",
+		"INVALID_BASIC_AUTH_CREDENTIALS": "Invalid credentials.",
+		"INVALID_CREDENTIALS":            "Invalid email or password.",
+		"INVALID_PASSWORD":               "Invalid password.",
+		"INVALID_REFRESH_TOKEN":          "Invalid refresh token.",
+		"INVALID_TOKEN":                  "Token is invalid or has expired.",
+		"TOKEN_REVOKED":                  "Token has been revoked.",
+		"NOT_AN_ACCESS_TOKEN":            "Token is not an access token.",
+		"TOKEN_MISSING_ID":               "Token is missing its id.",
+		"NOT_A_TWO_FACTOR_CHALLENGE":     "Token is not a two-factor challenge.",
+		"TOKEN_EXPIRED":                  "Token has expired.",
+		"TOKEN_REUSED":                   "Token was already used.",
+		"INVALID_SECOND_FACTOR":          "Invalid two-factor code.",
+		"TWO_FACTOR_ENABLED":             "Two-factor authentication is already enabled.",
+		"TWO_FACTOR_NOT_ENABLED":         "Two-factor authentication is not enabled.",
+		"TWO_FACTOR_NOT_STARTED":         "Two-factor enrolment has not been started.",
+		"TWO_FACTOR_CHANGED":             "Two-factor enrolment was completed or restarted in the meantime, start it again.",
+		"RECORD_NOT_FOUND":               "Record not found.",
+		"ALREADY_EXISTS":                 "Resource already exists.",
+		"EMAIL_IN_USE":                   "Email already in use.",
+		"USERNAME_IN_USE":                "Username already in use.",
+		"COMMENT_TOO_DEEP":               "Comment thread is too deep.",
+		"INVALID_CURSOR":                 "Invalid cursor.",
+		"INVALID_TIME_WINDOW":            "Invalid time window: until is before since.",
+		"INVALID_LIMIT":                  "Invalid limit, expected a number between 1 and 100.",
+		"PROFILE_MODIFIED":               "The profile was modified in the meantime, fetch it again and retry.",
+
+		// problem titles
+		"STATUS_400": "Bad Request",
+		"STATUS_401": "Unauthorized",
+		"STATUS_403": "Forbidden",
+		"STATUS_404": "Not Found",
+		"STATUS_409": "Conflict",
+		"STATUS_413": "Request Entity Too Large",
+		"STATUS_429": "Too Many Requests",
+		"STATUS_500": "Internal Server Error",
+
+		// request validation
+		"VALIDATION_ERROR":        "The request has invalid fields.",
+		"MALFORMED_JSON":          "The request body is not valid JSON.",
+		"MALFORMED_JSON_AT":       "The request body is not valid JSON (at byte %d).",
+		"EMPTY_BODY":              "The request body must not be empty.",
+		"BODY_TOO_LARGE":          "The request body must not be larger than %d bytes.",
+		"INVALID_PARAMETER":       "Invalid number: %q",
+		"INVALID_QUERY_PARAMETER": "Invalid %s: %q",
+		"INVALID_DEPTH":           "Invalid depth, at most %d levels of replies can be loaded.",
+		"INVALID_LOCALE":          "Invalid locale, expected one of: %s.",
+		"INVALID_PREVIEW_FORMAT":  "Invalid format, expected json, html or text.",
+		"BAD_REQUEST_ERROR":       "The request is invalid.",
+		"UNKNOWN_FIELD":           "Unknown field.",
+		"INVALID_TYPE":            "Must be %s.",
+		"TYPE_STRING":             "a string",
+		"TYPE_BOOLEAN":            "a boolean",
+		"TYPE_NUMBER":             "a number",
+		"TYPE_ARRAY":              "an array",
+		"TYPE_OBJECT":             "an object",
+		"FIELD_REQUIRED":          "This field is required.",
+		"FIELD_REQUIRED_WITHOUT":  "This field is required unless %s is given.",
+		"FIELD_EMAIL":             "Must be a valid email address.",
+		"FIELD_URL":               "Must be a valid URL.",
+		"FIELD_HTTP_URL":          "Must be a valid http or https URL.",
+		"FIELD_NUMERIC":           "Must only contain digits.",
+		"FIELD_ONEOF":             "Must be one of: %s.",
+		"FIELD_LEN_STRING":        "Must be exactly %s characters long.",
+		"FIELD_LEN_ITEMS":         "Must have exactly %s items.",
+		"FIELD_LEN_NUMBER":        "Must be %s.",
+		"FIELD_MIN_STRING":        "Must be at least %s characters long.",
+		"FIELD_MIN_ITEMS":         "Must have at least %s items.",
+		"FIELD_MIN_NUMBER":        "Must be at least %s.",
+		"FIELD_MAX_STRING":        "Must be at most %s characters long.",
+		"FIELD_MAX_ITEMS":         "Must have at most %s items.",
+		"FIELD_MAX_NUMBER":        "Must be at most %s.",
+		"FIELD_GT":                "Must be greater than %s.",
+		"FIELD_INVALID":           "Is invalid.",
+	},
+	"fr": {
+		// errors
+		"INTERNAL_SERVER_ERROR":          "Le serveur a rencontré un problème lors du traitement de votre requête.",
+		"CONFLICT_ERROR":                 "La requête est en conflit avec l'état actuel de la ressource.",
+		"UNAUTHORIZED_ERROR":             "Le jeton est invalide ou a expiré.",
+		"UNAUTHORIZED_BASIC_AUTH_ERROR":  "Identifiants invalides.",
+		"FORBIDDEN_ERROR":                "Cette action est interdite.",
+		"TOO_MANY_REQUESTS_ERROR":        "Trop de tentatives, veuillez réessayer plus tard.",
+		"NOT_FOUND_ERROR":                "Ressource introuvable.",
+		"INSUFFICIENT_SCOPE_ERROR":       "Ce jeton n'a pas la portée %s.",
+		"AUTH_HEADER_MISSING":            "L'en-tête Authorization est manquant.",
+		"AUTH_HEADER_MALFORMED":          "L'en-tête Authorization est mal formé.",
+		"INVALID_BASIC_AUTH_CREDENTIALS": "Identifiants invalides.",
+		"INVALID_CREDENTIALS":            "Email ou mot de passe invalide.",
+		"INVALID_PASSWORD":               "Mot de passe invalide.",
+		"INVALID_REFRESH_TOKEN":          "Jeton de rafraîchissement invalide.",
+		"INVALID_TOKEN":                  "Le jeton est invalide ou a expiré.",
+		"TOKEN_REVOKED":                  "Le jeton a été révoqué.",
+		"NOT_AN_ACCESS_TOKEN":            "Le jeton n'est pas un jeton d'accès.",
+		"TOKEN_MISSING_ID":               "Le jeton n'a pas d'identifiant.",
+		"NOT_A_TWO_FACTOR_CHALLENGE":     "Le jeton n'est pas un défi à deux facteurs.",
+		"TOKEN_EXPIRED":                  "Le jeton a expiré.",
+		"TOKEN_REUSED":                   "Le jeton a déjà été utilisé.",
+		"INVALID_SECOND_FACTOR":          "Code à deux facteurs invalide.",
+		"TWO_FACTOR_ENABLED":             "L'authentification à deux facteurs est déjà activée.",
+		"TWO_FACTOR_NOT_ENABLED":         "L'authentification à deux facteurs n'est pas activée.",
+		"TWO_FACTOR_NOT_STARTED":         "L'activation de l'authentification à deux facteurs n'a pas été commencée.",
+		"TWO_FACTOR_CHANGED":             "L'activation de l'authentification à deux facteurs a été terminée ou recommencée entre-temps, recommencez-la.",
+		"RECORD_NOT_FOUND":               "Enregistrement introuvable.",
+		"ALREADY_EXISTS":                 "La ressource existe déjà.",
+		"EMAIL_IN_USE":                   "Cet email est déjà utilisé.",
+		"USERNAME_IN_USE":                "Ce nom d'utilisateur est déjà utilisé.",
+		"COMMENT_TOO_DEEP":               "Le fil de commentaires est trop profond.",
+		"INVALID_CURSOR":                 "Curseur invalide.",
+		"INVALID_TIME_WINDOW":            "Période invalide : until est avant since.",
+		"INVALID_LIMIT":                  "Limite invalide, un nombre entre 1 et 100 est attendu.",
+		"PROFILE_MODIFIED":               "Le profil a été modifié entre-temps, rechargez-le puis réessayez.",
+
+		// problem titles
+		"STATUS_400": "Requête invalide",
+		"STATUS_401": "Non authentifié",
+		"STATUS_403": "Interdit",
+		"STATUS_404": "Introuvable",
+		"STATUS_409": "Conflit",
+		"STATUS_413": "Requête trop volumineuse",
+		"STATUS_429": "Trop de requêtes",
+		"STATUS_500": "Erreur interne du serveur",
+
+		// request validation
+		"VALIDATION_ERROR":        "La requête contient des champs invalides.",
+		"MALFORMED_JSON":          "Le corps de la requête n'est pas un JSON valide.",
+		"MALFORMED_JSON_AT":       "Le corps de la requête n'est pas un JSON valide (à l'octet %d).",
+		"EMPTY_BODY":              "Le corps de la requête ne doit pas être vide.",
+		"BODY_TOO_LARGE":          "Le corps de la requête ne doit pas dépasser %d octets.",
+		"INVALID_PARAMETER":       "Nombre invalide : %q",
+		"INVALID_QUERY_PARAMETER": "Paramètre %s invalide : %q",
+		"INVALID_DEPTH":           "Profondeur invalide, au plus %d niveaux de réponses peuvent être chargés.",
+		"INVALID_LOCALE":          "Langue invalide, valeurs possibles : %s.",
+		"INVALID_PREVIEW_FORMAT":  "Format invalide, valeurs possibles : json, html ou text.",
+		"BAD_REQUEST_ERROR":       "La requête est invalide.",
+		"UNKNOWN_FIELD":           "Champ inconnu.",
+		"INVALID_TYPE":            "Doit être %s.",
+		"TYPE_STRING":             "une chaîne de caractères",
+		"TYPE_BOOLEAN":            "un booléen",
+		"TYPE_NUMBER":             "un nombre",
+		"TYPE_ARRAY":              "un tableau",
+		"TYPE_OBJECT":             "un objet",
+		"FIELD_REQUIRED":          "Ce champ est obligatoire.",
+		"FIELD_REQUIRED_WITHOUT":  "Ce champ est obligatoire si %s n'est pas renseigné.",
+		"FIELD_EMAIL":             "Doit être une adresse email valide.",
+		"FIELD_URL":               "Doit être une URL valide.",
+		"FIELD_HTTP_URL":          "Doit être une URL http ou https valide.",
+		"FIELD_NUMERIC":           "Ne doit contenir que des chiffres.",
+		"FIELD_ONEOF":             "Doit être l'une des valeurs : %s.",
+		"FIELD_LEN_STRING":        "Doit contenir exactement %s caractères.",
+		"FIELD_LEN_ITEMS":         "Doit contenir exactement %s éléments.",
+		"FIELD_LEN_NUMBER":        "Doit être %s.",
+		"FIELD_MIN_STRING":        "Doit contenir au moins %s caractères.",
+		"FIELD_MIN_ITEMS":         "Doit contenir au moins %s éléments.",
+		"FIELD_MIN_NUMBER":        "Doit être supérieur ou égal à %s.",
+		"FIELD_MAX_STRING":        "Doit contenir au plus %s caractères.",
+		"FIELD_MAX_ITEMS":         "Doit contenir au plus %s éléments.",
+		"FIELD_MAX_NUMBER":        "Doit être inférieur ou égal à %s.",
+		"FIELD_GT":                "Doit être supérieur à %s.",
+		"FIELD_INVALID":           "Est invalide.",
+	},
+}
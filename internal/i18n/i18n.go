@@ -0,0 +1,123 @@
+package i18n
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default is the locale everything falls back to
+const Default = "en"
+
+// Supported are the locales with translations, as base language tags
+var Supported = []string{"en", "fr"}
+
+func IsSupported(locale string) bool {
+	return slices.Contains(Supported, locale)
+}
+
+// Match picks the supported locale the client prefers the most from an Accept-Language header, Default
+// when none of them is acceptable. Regional variants match their base language, so fr-CA gets fr.
+func Match(acceptLanguage string) string {
+	type preference struct {
+		lang string
+		q    float64
+	}
+
+	var prefs []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q <= 0 {
+			continue
+		}
+
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		prefs = append(prefs, preference{lang: base, q: q})
+	}
+
+	// stable, so that equally weighted languages keep the client's order
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if IsSupported(p.lang) {
+			return p.lang
+		}
+	}
+
+	return Default
+}
+
+// Message returns the message with the given id, an error code such as EMAIL_IN_USE, in the locale. Messages
+// that aren't translated yet are in English.
+func Message(locale, id string) (string, bool) {
+	if message, ok := catalog[locale][id]; ok {
+		return message, true
+	}
+	message, ok := catalog[Default][id]
+	return message, ok
+}
+
+// T is Message formatted with args, returning the id itself when there is no such message
+func T(locale, id string, args ...any) string {
+	message, ok := Message(locale, id)
+	if !ok {
+		return id
+	}
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// durationUnits are the singular and plural names of the units of Duration, by locale
+var durationUnits = map[string]map[time.Duration][2]string{
+	"en": {
+		time.Hour * 24: {"day", "days"},
+		time.Hour:      {"hour", "hours"},
+		time.Minute:    {"minute", "minutes"},
+	},
+	"fr": {
+		time.Hour * 24: {"jour", "jours"},
+		time.Hour:      {"heure", "heures"},
+		time.Minute:    {"minute", "minutes"},
+	},
+}
+
+// Duration writes d for people to read in the locale, such as "1 hour" or "3 days", in the largest unit
+// it is a whole number of, down to minutes
+func Duration(locale string, d time.Duration) string {
+	units, ok := durationUnits[locale]
+	if !ok {
+		units = durationUnits[Default]
+	}
+
+	unit := time.Minute
+	for _, u := range []time.Duration{time.Hour * 24, time.Hour} {
+		if d >= u && d%u == 0 {
+			unit = u
+			break
+		}
+	}
+
+	n := int64(d / unit)
+	name := units[unit][1]
+	if n == 1 {
+		name = units[unit][0]
+	}
+	return strconv.FormatInt(n, 10) + " " + name
+}
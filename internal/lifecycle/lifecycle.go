@@ -0,0 +1,86 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Manager runs the background tasks of the process and stops them on shutdown, telling meanwhile that
+// the process is draining so that it stops being sent traffic
+type Manager struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	draining atomic.Bool
+
+	mu      sync.Mutex
+	running map[string]int
+}
+
+func New() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{
+		ctx:     ctx,
+		cancel:  cancel,
+		running: make(map[string]int),
+	}
+}
+
+// Go runs task in the background, task being expected to return soon after its ctx is done
+func (m *Manager) Go(name string, task func(ctx context.Context)) {
+	m.mu.Lock()
+	m.running[name]++
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() {
+			m.mu.Lock()
+			if m.running[name]--; m.running[name] == 0 {
+				delete(m.running, name)
+			}
+			m.mu.Unlock()
+		}()
+
+		task(m.ctx)
+	}()
+}
+
+// Drain marks the process as draining, it is still serving but about to stop
+func (m *Manager) Drain() {
+	m.draining.Store(true)
+}
+
+func (m *Manager) Draining() bool {
+	return m.draining.Load()
+}
+
+// Stop drains the process, cancels the tasks and waits for them to return, or for ctx to be done
+func (m *Manager) Stop(ctx context.Context) error {
+	m.Drain()
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		names := slices.Sorted(maps.Keys(m.running))
+		m.mu.Unlock()
+
+		return fmt.Errorf("background tasks still running: %s: %w", strings.Join(names, ", "), ctx.Err())
+	}
+}
@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// FileMailer writes every email as an .eml file in a directory instead of sending it, for local development
+type FileMailer struct {
+	fromEmail string
+	dir       string
+}
+
+func NewFileMailer(dir, fromEmail string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{
+		fromEmail: fromEmail,
+		dir:       dir,
+	}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error) {
+	rendered, err := Render(templateFile, locale, data)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := &message{
+		from:     mail.Address{Name: FromName, Address: m.fromEmail},
+		to:       mail.Address{Name: username, Address: email},
+		Rendered: rendered,
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.eml",
+		time.Now().UTC().Format("20060102T150405.000000000"),
+		unsafeFileChars.ReplaceAllString(email, "_"),
+		unsafeFileChars.ReplaceAllString(templateFile, "_"),
+	)
+	if err := os.WriteFile(filepath.Join(m.dir, name), raw, 0o644); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// Ping checks that the directory is still there
+func (m *FileMailer) Ping(ctx context.Context) error {
+	info, err := os.Stat(m.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", m.dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// LogMailer logs every email instead of sending it, for local development
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogMailer(logger *zap.SugaredLogger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error) {
+	rendered, err := Render(templateFile, locale, data)
+	if err != nil {
+		return 0, err
+	}
+
+	// the plain-text part reads better in logs, when there is one
+	body := rendered.Text
+	if body == "" {
+		body = rendered.HTML
+	}
+
+	m.logger.Infow("Email not sent, logging it instead",
+		"template", templateFile,
+		"locale", locale,
+		"to", email,
+		"username", username,
+		"subject", rendered.Subject,
+		"body", body,
+	)
+
+	return 0, nil
+}
+
+func (m *LogMailer) Ping(ctx context.Context) error {
+	return nil
+}
@@ -1,18 +1,33 @@
 package mailer
 
-import "embed"
+import (
+	"context"
+	"embed"
+	"errors"
+)
 
 const (
-	FromName            = "GoSocial"
-	maxRetries          = 3
-	UserWelcomeTemplate = "user_verification.tmpl"
+	FromName              = "GoSocial"
+	UserWelcomeTemplate   = "user_verification.tmpl"
+	PasswordResetTemplate = "password_reset.tmpl"
+	EmailChangeTemplate   = "email_change.tmpl"
+	// EmailChangeNoticeTemplate warns the current address of a pending email change
+	EmailChangeNoticeTemplate = "email_change_notice.tmpl"
 )
 
+// templates are organised by locale, such as templates/fr/user_verification.tmpl, with templates/en as the fallback.
 // the line below ensures the template files will be embedded with the go binary at build time!
 
 //go:embed "templates"
 var FS embed.FS
 
+// ErrPermanent wraps the errors that retrying won't fix, such as a broken template or a rejected recipient
+var ErrPermanent = errors.New("permanent failure")
+
+// Client sends templateFile rendered in the locale, or in English when the locale has no such template, giving up
+// once ctx is done
 type Client interface {
-	Send(templateFile, username, email string, data any, isSandbox bool) (int, error)
+	Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error)
+	// Ping checks that emails can be handed over, without sending any
+	Ping(ctx context.Context) error
 }
@@ -0,0 +1,95 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// message is a rendered email, ready to be written out in the RFC 5322 format. It is sent as
+// multipart/alternative when it has a plain-text part, and as HTML only otherwise.
+type message struct {
+	from mail.Address
+	to   mail.Address
+	*Rendered
+}
+
+func (m *message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintf(buf, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", m.to.String())
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: %s\r\n", messageID(m.from.Address))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.Text == "" {
+		buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(buf, m.HTML); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%q\r\n", mw.Boundary())
+	buf.WriteString("\r\n")
+
+	// the preferred part goes last
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", m.Text},
+		{"text/html; charset=UTF-8", m.HTML},
+	}
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
@@ -1,10 +1,9 @@
 package mailer
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"html/template"
-	"time"
+	"net/http"
 
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
@@ -25,29 +24,23 @@ func NewSendgrid(apiKey, fromEmail string) *SendGridMailer {
 	}
 }
 
-func (m *SendGridMailer) Send(templateFile, username, email string, data any, isSandbox bool) (int, error) {
+func (m *SendGridMailer) Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error) {
 	from := mail.NewEmail(FromName, m.fromEmail)
 	to := mail.NewEmail(username, email)
 
-	// template parsing and building
-	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	rendered, err := Render(templateFile, locale, data)
 	if err != nil {
 		return 0, err
 	}
 
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return 0, err
-	}
-
-	body := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(body, "body", data)
-	if err != nil {
-		return 0, err
+	// SendGrid wants the plain-text content first, and rejects empty contents
+	contents := []*mail.Content{}
+	if rendered.Text != "" {
+		contents = append(contents, mail.NewContent("text/plain", rendered.Text))
 	}
+	contents = append(contents, mail.NewContent("text/html", rendered.HTML))
 
-	message := mail.NewSingleEmail(from, subject.String(), to, "", body.String())
+	message := mail.NewV3MailInit(from, rendered.Subject, to, contents...)
 
 	message.SetMailSettings(&mail.MailSettings{
 		SandboxMode: &mail.Setting{
@@ -55,17 +48,41 @@ func (m *SendGridMailer) Send(templateFile, username, email string, data any, is
 		},
 	})
 
-	var retryErr error
-	for i := 0; i < maxRetries; i++ {
-		res, retryErr := m.client.Send(message)
-		if retryErr != nil {
-			// exponential backoff
-			time.Sleep(time.Second * time.Duration(i+1))
-			continue
-		}
+	// retrying is left to the caller, the outbox worker backs off between attempts
+	res, err := m.client.SendWithContext(ctx, message)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return res.StatusCode, fmt.Errorf("sendgrid responded %d: %s", res.StatusCode, res.Body)
+	case res.StatusCode >= 400:
+		return res.StatusCode, fmt.Errorf("%w: sendgrid responded %d: %s", ErrPermanent, res.StatusCode, res.Body)
+	}
+
+	return res.StatusCode, nil
+}
+
+// sendgridScopesURL lists the permissions of the API key, which checks the key without side effects
+const sendgridScopesURL = "https://api.sendgrid.com/v3/scopes"
+
+func (m *SendGridMailer) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sendgridScopesURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.apiKey)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
 
-		return res.StatusCode, nil
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("sendgrid responded %d", res.StatusCode)
 	}
 
-	return 0, fmt.Errorf("Failed to send email after %d attempts, error: %v", maxRetries, retryErr)
+	return nil
 }
@@ -0,0 +1,158 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const (
+	// smtpOK is the reply code of an accepted message, returned as the status of successful sends
+	smtpOK = 250
+	// smtpTimeout bounds a whole send, so that a server that stops answering doesn't hold a mail worker forever
+	smtpTimeout = time.Second * 30
+)
+
+type SMTPMailer struct {
+	fromEmail string
+	addr      string
+	host      string
+	auth      smtp.Auth
+	timeout   time.Duration
+	// tlsConfig is the base of the STARTTLS configuration, nil for the defaults
+	tlsConfig *tls.Config
+}
+
+// NewSMTP sends through an SMTP server, upgrading to TLS when it offers STARTTLS, and authenticating when
+// a username is given.
+func NewSMTP(host string, port int, username, password, fromEmail string) *SMTPMailer {
+	m := &SMTPMailer{
+		fromEmail: fromEmail,
+		addr:      net.JoinHostPort(host, strconv.Itoa(port)),
+		host:      host,
+		timeout:   smtpTimeout,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send ignores isSandbox, which is a SendGrid feature: point the mailer at a local catcher such as MailHog instead.
+func (m *SMTPMailer) Send(ctx context.Context, templateFile, locale, username, email string, data any, isSandbox bool) (int, error) {
+	rendered, err := Render(templateFile, locale, data)
+	if err != nil {
+		return 0, err
+	}
+
+	msg := &message{
+		from:     mail.Address{Name: FromName, Address: m.fromEmail},
+		to:       mail.Address{Name: username, Address: email},
+		Rendered: rendered,
+	}
+
+	raw, err := msg.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.send(ctx, email, raw); err != nil {
+		// 5xx replies are permanent, such as an unknown recipient
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return reply.Code, fmt.Errorf("%w: %v", ErrPermanent, err)
+		}
+		return 0, err
+	}
+
+	return smtpOK, nil
+}
+
+func (m *SMTPMailer) send(ctx context.Context, to string, raw []byte) error {
+	d := net.Dialer{Timeout: m.timeout}
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := &tls.Config{}
+		if m.tlsConfig != nil {
+			cfg = m.tlsConfig.Clone()
+		}
+		cfg.ServerName = m.host
+
+		if err := c.StartTLS(cfg); err != nil {
+			return err
+		}
+	}
+
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.fromEmail); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// Ping connects to the server and waits for its greeting
+func (m *SMTPMailer) Ping(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
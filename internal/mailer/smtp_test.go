@@ -0,0 +1,417 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpSession is what the stub server received over a connection
+type smtpSession struct {
+	tls      bool
+	auth     string
+	from     string
+	rcpt     []string
+	data     string
+	quit     bool
+	commands []string
+}
+
+// smtpStub is an in-process SMTP server speaking just enough of EHLO, STARTTLS, AUTH PLAIN, MAIL, RCPT and DATA
+type smtpStub struct {
+	t        *testing.T
+	listener net.Listener
+	tls      *tls.Config
+	// rejectRcpt is answered with 550 when given as the recipient
+	rejectRcpt string
+	// silent servers accept connections without ever greeting
+	silent bool
+
+	mu       sync.Mutex
+	sessions []*smtpSession
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newSMTPStub starts a stub server, configure setting its behaviour up before it accepts connections
+func newSMTPStub(t *testing.T, configure func(*smtpStub)) (*smtpStub, *x509.CertPool) {
+	t.Helper()
+
+	cert, pool := selfSignedCert(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpStub{
+		t:        t,
+		listener: l,
+		tls:      &tls.Config{Certificates: []tls.Certificate{cert}},
+		done:     make(chan struct{}),
+	}
+	if configure != nil {
+		configure(s)
+	}
+
+	go s.serve()
+	t.Cleanup(s.stop)
+
+	return s, pool
+}
+
+// stop closes the listener and waits for the connections to be over
+func (s *smtpStub) stop() {
+	s.stopOnce.Do(func() {
+		s.listener.Close()
+		<-s.done
+	})
+}
+
+func (s *smtpStub) mailer(t *testing.T, pool *x509.CertPool, username, password string) *SMTPMailer {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(s.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewSMTP(host, p, username, password, "noreply@gosocial.test")
+	m.tlsConfig = &tls.Config{RootCAs: pool}
+	return m
+}
+
+func (s *smtpStub) serve() {
+	defer close(s.done)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+
+			if s.silent {
+				// holds the connection until the client gives up
+				io.Copy(io.Discard, conn)
+				return
+			}
+
+			session := &smtpSession{}
+			s.mu.Lock()
+			s.sessions = append(s.sessions, session)
+			s.mu.Unlock()
+
+			s.handle(conn, session)
+		}()
+	}
+}
+
+func (s *smtpStub) handle(conn net.Conn, session *smtpSession) {
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 stub ESMTP ready")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+
+		s.mu.Lock()
+		session.commands = append(session.commands, verb)
+		s.mu.Unlock()
+
+		switch verb {
+		case "EHLO":
+			if session.tls {
+				tp.PrintfLine("250-stub greets %s", arg)
+				tp.PrintfLine("250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250-stub greets %s", arg)
+				tp.PrintfLine("250-STARTTLS")
+				tp.PrintfLine("250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready to start TLS")
+
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				s.t.Errorf("TLS handshake: %v", err)
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			session.tls = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			decoded, err := base64.StdEncoding.DecodeString(initial)
+			if mechanism != "PLAIN" || err != nil {
+				tp.PrintfLine("504 unsupported authentication")
+				continue
+			}
+			session.auth = string(decoded)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			session.from = strings.TrimPrefix(arg, "FROM:")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			rcpt := strings.TrimPrefix(arg, "TO:")
+			if rcpt == "<"+s.rejectRcpt+">" {
+				tp.PrintfLine("550 no such user")
+				continue
+			}
+			session.rcpt = append(session.rcpt, rcpt)
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			session.data = string(data)
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			session.quit = true
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// session stops the server and returns the one session it had
+func (s *smtpStub) session(t *testing.T) *smtpSession {
+	t.Helper()
+
+	s.stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) != 1 {
+		t.Fatalf("got %d SMTP sessions, want 1", len(s.sessions))
+	}
+	return s.sessions[0]
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stub"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+var welcomeData = struct {
+	Username      string
+	ActivationURL string
+}{
+	Username:      "jane",
+	ActivationURL: "https://gosocial.test/confirm/0f8c1b7e",
+}
+
+func TestSMTPMailerSendsMultipartMessage(t *testing.T) {
+	stub, pool := newSMTPStub(t, nil)
+	m := stub.mailer(t, pool, "mailer", "s3cret")
+
+	status, err := m.Send(context.Background(), UserWelcomeTemplate, "en", "Jane Doe", "jane@gosocial.test", welcomeData, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != smtpOK {
+		t.Errorf("status = %d, want %d", status, smtpOK)
+	}
+
+	session := stub.session(t)
+	if !session.tls {
+		t.Error("the session wasn't upgraded with STARTTLS")
+	}
+	if session.auth != "\x00mailer\x00s3cret" {
+		t.Errorf("AUTH PLAIN = %q, want the username and password", session.auth)
+	}
+	if session.from != "<noreply@gosocial.test>" {
+		t.Errorf("MAIL FROM = %s, want <noreply@gosocial.test>", session.from)
+	}
+	if len(session.rcpt) != 1 || session.rcpt[0] != "<jane@gosocial.test>" {
+		t.Errorf("RCPT TO = %v, want [<jane@gosocial.test>]", session.rcpt)
+	}
+	if !session.quit {
+		t.Error("the client didn't QUIT")
+	}
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(session.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to, err := msg.Header.AddressList("To")
+	if err != nil || len(to) != 1 || to[0].Name != "Jane Doe" || to[0].Address != "jane@gosocial.test" {
+		t.Errorf("To = %v (%v), want Jane Doe <jane@gosocial.test>", to, err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Address != "noreply@gosocial.test" {
+		t.Errorf("From = %v (%v), want noreply@gosocial.test", from, err)
+	}
+	if subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); err != nil || subject != "Finish Registration with GoSocial" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %s, want multipart/alternative", mediaType)
+	}
+
+	// the parts are decoded from quoted-printable by the reader
+	var parts []string
+	bodies := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, contentType)
+		bodies[contentType] = string(body)
+	}
+
+	if strings.Join(parts, ",") != "text/plain,text/html" {
+		t.Fatalf("parts = %v, want text/plain then text/html", parts)
+	}
+	if !strings.Contains(bodies["text/plain"], "Hello jane,") || !strings.Contains(bodies["text/plain"], welcomeData.ActivationURL) {
+		t.Errorf("text part is missing the greeting or the link:\n%s", bodies["text/plain"])
+	}
+	if !strings.Contains(bodies["text/html"], `href="`+welcomeData.ActivationURL+`"`) {
+		t.Errorf("HTML part is missing the link:\n%s", bodies["text/html"])
+	}
+	if strings.Contains(bodies["text/plain"], "<p>") {
+		t.Error("text part has HTML in it")
+	}
+}
+
+func TestSMTPMailerWithoutAuth(t *testing.T) {
+	stub, pool := newSMTPStub(t, nil)
+	m := stub.mailer(t, pool, "", "")
+
+	if _, err := m.Send(context.Background(), UserWelcomeTemplate, "en", "jane", "jane@gosocial.test", welcomeData, false); err != nil {
+		t.Fatal(err)
+	}
+
+	session := stub.session(t)
+	for _, command := range session.commands {
+		if command == "AUTH" {
+			t.Error("the client authenticated without a username")
+		}
+	}
+	if !session.tls {
+		t.Error("the session wasn't upgraded with STARTTLS")
+	}
+}
+
+func TestSMTPMailerRejectedRecipientIsPermanent(t *testing.T) {
+	stub, pool := newSMTPStub(t, func(s *smtpStub) { s.rejectRcpt = "ghost@gosocial.test" })
+	m := stub.mailer(t, pool, "mailer", "s3cret")
+
+	status, err := m.Send(context.Background(), UserWelcomeTemplate, "en", "ghost", "ghost@gosocial.test", welcomeData, false)
+	if !errors.Is(err, ErrPermanent) {
+		t.Fatalf("err = %v, want ErrPermanent", err)
+	}
+	if status != 550 {
+		t.Errorf("status = %d, want 550", status)
+	}
+	if data := stub.session(t).data; data != "" {
+		t.Errorf("a message was sent to a rejected recipient:\n%s", data)
+	}
+}
+
+func TestSMTPMailerTimesOutOnSilentServer(t *testing.T) {
+	stub, pool := newSMTPStub(t, func(s *smtpStub) { s.silent = true })
+	m := stub.mailer(t, pool, "", "")
+	m.timeout = time.Millisecond * 200
+
+	start := time.Now()
+	_, err := m.Send(context.Background(), UserWelcomeTemplate, "en", "jane", "jane@gosocial.test", welcomeData, false)
+	if err == nil {
+		t.Fatal("Send succeeded against a server that never greets")
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("Send took %s, want about %s", elapsed, m.timeout)
+	}
+}
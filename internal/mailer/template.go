@@ -0,0 +1,129 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/mustaphalimar/go-social/internal/i18n"
+)
+
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Rendered is an email ready to send, Text being empty when the template has no "text" block
+type Rendered struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// mailTemplate holds the two parses of a template: the HTML part goes through html/template, within the
+// shared layout, while the subject and the plain-text part must not be HTML escaped.
+type mailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// templates are parsed once, when the program starts, so a broken template fails the startup instead of a send.
+// They are kept by locale, then by name.
+var templates = mustParseTemplates()
+
+func mustParseTemplates() map[string]map[string]*mailTemplate {
+	dirs, err := fs.ReadDir(FS, "templates")
+	if err != nil {
+		panic(err)
+	}
+
+	parsed := map[string]map[string]*mailTemplate{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || dir.Name() == "layouts" {
+			continue
+		}
+		locale := dir.Name()
+
+		files, err := fs.Glob(FS, path.Join("templates", locale, "*.tmpl"))
+		if err != nil {
+			panic(err)
+		}
+
+		// the layout of the locale, if any, redefines the English blocks of the base one
+		layouts := []string{"templates/layouts/base.tmpl"}
+		if _, err := fs.Stat(FS, path.Join("templates/layouts", locale+".tmpl")); err == nil {
+			layouts = append(layouts, path.Join("templates/layouts", locale+".tmpl"))
+		}
+
+		parsed[locale] = make(map[string]*mailTemplate, len(files))
+		for _, file := range files {
+			html, err := htmltemplate.ParseFS(FS, append(layouts, file)...)
+			if err != nil {
+				panic(fmt.Sprintf("mailer: parsing %s: %v", file, err))
+			}
+
+			text, err := texttemplate.ParseFS(FS, file)
+			if err != nil {
+				panic(fmt.Sprintf("mailer: parsing %s: %v", file, err))
+			}
+
+			parsed[locale][path.Base(file)] = &mailTemplate{html: html, text: text}
+		}
+	}
+
+	return parsed
+}
+
+// Templates lists the email templates, sorted by name
+func Templates() []string {
+	names := make([]string, 0, len(templates[i18n.Default]))
+	for name := range templates[i18n.Default] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookup finds a template in the locale, falling back to English when the locale doesn't have it
+func lookup(templateFile, locale string) (*mailTemplate, bool) {
+	if tmpl, ok := templates[locale][templateFile]; ok {
+		return tmpl, true
+	}
+
+	tmpl, ok := templates[i18n.Default][templateFile]
+	return tmpl, ok
+}
+
+// Render executes a template in the locale with the given data, its errors being permanent
+func Render(templateFile, locale string, data any) (*Rendered, error) {
+	tmpl, ok := lookup(templateFile, locale)
+	if !ok {
+		return nil, fmt.Errorf("%w: %w %q", ErrPermanent, ErrUnknownTemplate, templateFile)
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.text.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+
+	html := new(bytes.Buffer)
+	if err := tmpl.html.ExecuteTemplate(html, "body", data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+
+	text := new(bytes.Buffer)
+	if tmpl.text.Lookup("text") != nil {
+		if err := tmpl.text.ExecuteTemplate(text, "text", data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPermanent, err)
+		}
+	}
+
+	return &Rendered{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type window struct {
+	start  time.Time
+	length time.Duration
+	prev   int
+	curr   int
+}
+
+// Memory keeps the windows in the process, each replica then enforcing the limits on its own
+type Memory struct {
+	mu      sync.Mutex
+	windows map[string]*window
+}
+
+func NewMemory() *Memory {
+	return &Memory{windows: make(map[string]*window)}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	start := windowStart(now, limit)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.windows[key]
+	switch {
+	case !ok || w.length != limit.Window:
+		w = &window{start: start, length: limit.Window}
+		m.windows[key] = w
+	case start.Sub(w.start) == limit.Window:
+		w.start, w.prev, w.curr = start, w.curr, 0
+	case start.After(w.start):
+		w.start, w.prev, w.curr = start, 0, 0
+	}
+
+	isAllowed := allowed(w.prev, w.curr, weight(now, limit), limit)
+	if isAllowed {
+		w.curr++
+	}
+
+	return result(now, limit, w.prev, w.curr, isAllowed), nil
+}
+
+func (m *Memory) Cleanup(ctx context.Context) (int64, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for key, w := range m.windows {
+		// past the window after it, its requests don't weigh anymore
+		if now.Sub(w.start) >= 2*w.length {
+			delete(m.windows, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
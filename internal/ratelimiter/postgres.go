@@ -0,0 +1,80 @@
+package ratelimiter
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const queryTimeoutDuration = time.Second * 5
+
+// Postgres keeps the windows in the rate_limits table, so that the limits hold across replicas
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	// the request is only counted if the weighted count is under the limit, the check and the increment
+	// being atomic as the conflicting row is locked before the WHERE of the update is evaluated
+	query := `
+		WITH prev AS (
+			SELECT COALESCE(SUM(count), 0)::int AS count FROM rate_limits WHERE key = $1 AND window_start = $3
+		), hit AS (
+			INSERT INTO rate_limits (key, window_start, count, expires_at)
+			SELECT $1, $2, 1, $6 FROM prev WHERE prev.count * $4::float8 < $5
+			ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limits.count + 1
+			WHERE rate_limits.count + (SELECT count FROM prev) * $4::float8 < $5
+			RETURNING count
+		)
+		SELECT
+			(SELECT count FROM prev),
+			(SELECT count FROM hit),
+			(SELECT count FROM rate_limits WHERE key = $1 AND window_start = $2)
+	`
+	ctx, cancel := context.WithTimeout(ctx, queryTimeoutDuration)
+	defer cancel()
+
+	now := time.Now()
+	start := windowStart(now, limit)
+
+	var (
+		prev     int
+		hit      sql.NullInt64
+		snapshot sql.NullInt64
+	)
+	err := p.db.QueryRowContext(ctx, query,
+		key,
+		start,
+		start.Add(-limit.Window),
+		weight(now, limit),
+		limit.Requests,
+		start.Add(2*limit.Window),
+	).Scan(&prev, &hit, &snapshot)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// the statement sees the table as it was before the insert, hence the count of a denied request
+	if hit.Valid {
+		return result(now, limit, prev, int(hit.Int64), true), nil
+	}
+	return result(now, limit, prev, int(snapshot.Int64), false), nil
+}
+
+func (p *Postgres) Cleanup(ctx context.Context) (int64, error) {
+	query := `DELETE FROM rate_limits WHERE expires_at < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeoutDuration)
+	defer cancel()
+
+	res, err := p.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,84 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Limiter counts requests by key with a sliding window: the requests of the current fixed window are
+// added to those of the previous one, weighted by how much of it the sliding window still covers
+type Limiter interface {
+	// Allow counts a request against key, unless it would go over limit
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	// Cleanup forgets the windows that no longer count, returning how many there were
+	Cleanup(ctx context.Context) (int64, error)
+}
+
+// Limit allows Requests per Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Policy describes the limit as in the RateLimit-Policy header, e.g. 10;w=60
+func (l Limit) Policy() string {
+	return fmt.Sprintf("%d;w=%d", l.Requests, int(l.Window.Seconds()))
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+	// Reset is when the current window ends
+	Reset time.Duration
+	// RetryAfter is how long a denied request must wait for the count to go under the limit
+	RetryAfter time.Duration
+}
+
+// windowStart is the start of the fixed window now is in
+func windowStart(now time.Time, limit Limit) time.Time {
+	return now.Truncate(limit.Window)
+}
+
+// weight is the part of the previous window still covered by the sliding window ending now
+func weight(now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(windowStart(now, limit))
+	return 1 - float64(elapsed)/float64(limit.Window)
+}
+
+func allowed(prev, curr int, weight float64, limit Limit) bool {
+	return float64(prev)*weight+float64(curr) < float64(limit.Requests)
+}
+
+// result describes the sliding window once the request was counted or denied, curr including the request
+// when allowed
+func result(now time.Time, limit Limit, prev, curr int, isAllowed bool) Result {
+	elapsed := now.Sub(windowStart(now, limit))
+	count := float64(prev)*weight(now, limit) + float64(curr)
+
+	res := Result{
+		Allowed:   isAllowed,
+		Limit:     limit,
+		Remaining: max(0, int(math.Floor(float64(limit.Requests)-count))),
+		Reset:     limit.Window - elapsed,
+	}
+	if isAllowed {
+		return res
+	}
+
+	requests := float64(limit.Requests)
+	window := float64(limit.Window)
+	switch {
+	case curr >= limit.Requests:
+		// the window must end, and enough of it slide by, for its requests to weigh under the limit
+		res.RetryAfter = res.Reset + time.Duration(window*(1-requests/float64(curr)))
+	case prev > 0:
+		// enough of the previous window must slide by
+		res.RetryAfter = time.Duration(window*(1-(requests-float64(curr))/float64(prev))) - elapsed
+	}
+	res.RetryAfter = max(res.RetryAfter, time.Second)
+
+	return res
+}
@@ -3,15 +3,23 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
+// MaxCommentDepth is how many levels of replies a top level comment can have
+const MaxCommentDepth = 4
+
 type Comment struct {
-	ID        int64  `json:"id"`
-	PostID    int64  `json:"post_id"`
-	UserID    int64  `json:"user_id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	User      User   `json:"user"`
+	ID           int64      `json:"id"`
+	PostID       int64      `json:"post_id"`
+	UserID       int64      `json:"user_id"`
+	ParentID     *int64     `json:"parent_id"`
+	Depth        int        `json:"depth"`
+	Content      string     `json:"content"`
+	CreatedAt    string     `json:"created_at"`
+	User         User       `json:"user"`
+	RepliesCount int        `json:"replies_count"`
+	Replies      []*Comment `json:"replies,omitempty"`
 }
 
 type CommentStore struct {
@@ -25,7 +33,7 @@ func (c *CommentStore) DeleteAll(ctx context.Context) error {
 
 func (c *CommentStore) GetByPostId(ctx context.Context, postId int64) ([]Comment, error) {
 	query := `
-		SELECT c.id,c.post_id,c.user_id,content,c.created_at,users.username,users.id  FROM comments c JOIN users ON users.id = c.user_id WHERE c.post_id = $1 ORDER BY c.created_at DESC;
+		SELECT c.id,c.post_id,c.user_id,c.parent_id,c.depth,content,c.created_at,users.username,users.id  FROM comments c JOIN users ON users.id = c.user_id WHERE c.post_id = $1 ORDER BY c.created_at DESC;
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
@@ -42,7 +50,7 @@ func (c *CommentStore) GetByPostId(ctx context.Context, postId int64) ([]Comment
 	for rows.Next() {
 		var c Comment
 		c.User = User{}
-		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.CreatedAt, &c.User.Username, &c.User.ID)
+		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentID, &c.Depth, &c.Content, &c.CreatedAt, &c.User.Username, &c.User.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -52,7 +60,122 @@ func (c *CommentStore) GetByPostId(ctx context.Context, postId int64) ([]Comment
 	return comments, nil
 }
 
+func (c *CommentStore) GetById(ctx context.Context, commentId int64) (*Comment, error) {
+	query := `
+		SELECT c.id,c.post_id,c.user_id,c.parent_id,c.depth,c.content,c.created_at,users.username,users.id,
+			(SELECT count(*) FROM comments r WHERE r.parent_id = c.id) AS replies_count
+		FROM comments c
+		JOIN users ON users.id = c.user_id
+		WHERE c.id = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var comment Comment
+	err := c.db.QueryRowContext(ctx, query, commentId).Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Depth,
+		&comment.Content,
+		&comment.CreatedAt,
+		&comment.User.Username,
+		&comment.User.ID,
+		&comment.RepliesCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+// GetThread loads a page of the direct children of parentId (top level comments when nil), along with
+// up to fq.Depth levels of their replies, fq.RepliesLimit per comment.
+func (c *CommentStore) GetThread(ctx context.Context, postId int64, parentId *int64, fq PaginatedCommentsQuery) ([]*Comment, error) {
+	query := `
+		WITH RECURSIVE thread AS (
+			SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.created_at, 0 AS level
+			FROM (
+				SELECT * FROM comments
+				WHERE post_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+				ORDER BY created_at DESC, id DESC
+				LIMIT $3 OFFSET $4
+			) c
+			UNION ALL
+			SELECT r.id, r.post_id, r.user_id, r.parent_id, r.depth, r.content, r.created_at, t.level + 1
+			FROM thread t
+			CROSS JOIN LATERAL (
+				SELECT * FROM comments
+				WHERE parent_id = t.id
+				ORDER BY created_at ASC, id ASC
+				LIMIT $5
+			) r
+			WHERE t.level < $6
+		)
+		SELECT t.id, t.post_id, t.user_id, t.parent_id, t.depth, t.content, t.created_at, u.username, u.id,
+			(SELECT count(*) FROM comments r WHERE r.parent_id = t.id) AS replies_count
+		FROM thread t
+		JOIN users u ON u.id = t.user_id
+		ORDER BY t.level, t.created_at, t.id;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := c.db.QueryContext(ctx, query, postId, parentId, fq.Limit, fq.Offset, fq.RepliesLimit, fq.Depth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// rows come ordered by level, so a parent is always seen before its replies
+	thread := []*Comment{}
+	byId := map[int64]*Comment{}
+
+	for rows.Next() {
+		c := &Comment{}
+		err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.ParentID, &c.Depth, &c.Content, &c.CreatedAt, &c.User.Username, &c.User.ID, &c.RepliesCount)
+		if err != nil {
+			return nil, err
+		}
+		byId[c.ID] = c
+
+		if c.ParentID != nil {
+			if parent, ok := byId[*c.ParentID]; ok {
+				parent.Replies = append(parent.Replies, c)
+				continue
+			}
+		}
+		thread = append(thread, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the page itself is newest first, replies read oldest first
+	for i, j := 0, len(thread)-1; i < j; i, j = i+1, j-1 {
+		thread[i], thread[j] = thread[j], thread[i]
+	}
+
+	return thread, nil
+}
+
 func (c *CommentStore) Create(ctx context.Context, comment *Comment) error {
+	if comment.ParentID != nil {
+		return withTx(c.db, ctx, func(tx *sql.Tx) error {
+			return c.createReply(ctx, tx, comment)
+		})
+	}
+
 	query := `
 		INSERT INTO comments (post_id,user_id,content) VALUES ($1,$2,$3) RETURNING id, created_at;
 	`
@@ -67,3 +190,89 @@ func (c *CommentStore) Create(ctx context.Context, comment *Comment) error {
 
 	return nil
 }
+
+func (c *CommentStore) createReply(ctx context.Context, tx *sql.Tx, comment *Comment) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// the parent has to live under the same post, and the thread can't grow past MaxCommentDepth
+	var parentDepth int
+	err := tx.QueryRowContext(ctx,
+		`SELECT depth FROM comments WHERE id = $1 AND post_id = $2 FOR SHARE`,
+		*comment.ParentID, comment.PostID,
+	).Scan(&parentDepth)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		default:
+			return err
+		}
+	}
+
+	if parentDepth >= MaxCommentDepth {
+		return ErrCommentTooDeep
+	}
+	comment.Depth = parentDepth + 1
+
+	query := `
+		INSERT INTO comments (post_id,user_id,content,parent_id,depth) VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at;
+	`
+	return tx.QueryRowContext(ctx, query,
+		comment.PostID,
+		comment.UserID,
+		comment.Content,
+		comment.ParentID,
+		comment.Depth,
+	).Scan(&comment.ID, &comment.CreatedAt)
+}
+
+func (c *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `
+		UPDATE comments SET content = $1 WHERE id = $2;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := c.db.ExecContext(ctx, query, comment.Content, comment.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+func (c *CommentStore) Delete(ctx context.Context, commentId int64) error {
+	query := `
+		DELETE FROM comments WHERE id = $1;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := c.db.ExecContext(ctx, query, commentId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
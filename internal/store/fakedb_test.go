@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver counting the rows inserted in each table, those inserted in a transaction
+// only counting once it commits. Statements containing a string given to failOn fail.
+type fakeDB struct {
+	mu        sync.Mutex
+	committed map[string]int
+	failures  []string
+}
+
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	t.Helper()
+
+	f := &fakeDB{committed: map[string]int{}}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+
+	return f, db
+}
+
+func (f *fakeDB) failOn(statement string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statement)
+}
+
+// rows is the number of committed rows of table
+func (f *fakeDB) rows(table string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.committed[table]
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+var (
+	insertPattern    = regexp.MustCompile(`(?i)INSERT\s+INTO\s+(\w+)`)
+	returningPattern = regexp.MustCompile(`(?is)RETURNING\s+(.+)$`)
+)
+
+type fakeConn struct {
+	db *fakeDB
+	// pending are the rows inserted by the transaction in progress, if any
+	pending map[string]int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.pending = map[string]int{}
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for table, n := range c.pending {
+		c.db.committed[table] += n
+	}
+	c.pending = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.pending = nil
+	return nil
+}
+
+// run applies the statement, returning the number of rows it inserts
+func (c *fakeConn) run(query string) (int64, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, failure := range c.db.failures {
+		if strings.Contains(query, failure) {
+			return 0, errors.New("fakedb: " + failure + " failed")
+		}
+	}
+
+	m := insertPattern.FindStringSubmatch(query)
+	if m == nil {
+		return 0, nil
+	}
+	if c.pending != nil {
+		c.pending[m[1]]++
+	} else {
+		c.db.committed[m[1]]++
+	}
+	return 1, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	n, err := s.conn.run(s.query)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(n), nil
+}
+
+// Query returns a row of ones, with a column per column of the RETURNING clause
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	if _, err := s.conn.run(s.query); err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if m := returningPattern.FindStringSubmatch(s.query); m != nil {
+		columns = strings.Split(strings.TrimSpace(m[1]), ",")
+	}
+	return &fakeRows{columns: columns}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	done    bool
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	for i := range dest {
+		dest[i] = int64(1)
+	}
+	return nil
+}
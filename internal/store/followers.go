@@ -28,15 +28,20 @@ func (s *FollowerStore) Follow(ctx context.Context, userToFollow int64, followin
 		return ErrConflict
 	}
 
-	return nil
+	return err
 }
 
-func (s *FollowerStore) Unfollow(ctx context.Context, userToUnfollow int64, unfollowingUser int64) error {
+// Unfollow returns whether the user was followed, as the number of follows deleted
+func (s *FollowerStore) Unfollow(ctx context.Context, userToUnfollow int64, unfollowingUser int64) (int64, error) {
 	query := `
 		DELETE FROM followers WHERE user_id=$1 AND follower_id=$2
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
-	_, err := s.db.ExecContext(ctx, query, userToUnfollow, unfollowingUser)
-	return err
+	res, err := s.db.ExecContext(ctx, query, userToUnfollow, unfollowingUser)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
 }
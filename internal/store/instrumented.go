@@ -0,0 +1,486 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Observer is called before every call to the store, returning the context to make it with and a function
+// to call once it returns, such as to time it. The function is given the number of records returned
+// or affected, -1 when the call can't tell as with TRUNCATE, and the error.
+type Observer func(ctx context.Context, store, method string) (context.Context, func(rows int, err error))
+
+type instrumented struct {
+	next    Storage
+	observe Observer
+}
+
+// affected is the number of records of a call writing or reading exactly one, or failing
+func affected(err error) int {
+	if err != nil {
+		return 0
+	}
+	return 1
+}
+
+func found(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+func feedRows(page *FeedPage) int {
+	if page == nil {
+		return 0
+	}
+	return len(page.Posts)
+}
+
+// Instrument wraps every store of s so that observe sees all their calls
+func Instrument(s Storage, observe Observer) Storage {
+	i := &instrumented{next: s, observe: observe}
+
+	return Storage{
+		Posts:          instrumentedPosts{i},
+		Users:          instrumentedUsers{i},
+		Comments:       instrumentedComments{i},
+		Followers:      instrumentedFollowers{i},
+		Roles:          instrumentedRoles{i},
+		Tokens:         instrumentedTokens{i},
+		PersonalTokens: instrumentedPersonalTokens{i},
+		TwoFactor:      instrumentedTwoFactor{i},
+		Logins:         instrumentedLogins{i},
+		Search:         instrumentedSearch{i},
+		MailOutbox:     instrumentedMailOutbox{i},
+	}
+}
+
+type instrumentedPosts struct{ *instrumented }
+
+func (s instrumentedPosts) Create(ctx context.Context, post *Post) error {
+	ctx, done := s.observe(ctx, "posts", "Create")
+	err := s.next.Posts.Create(ctx, post)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedPosts) GetById(ctx context.Context, id int64) (*Post, error) {
+	ctx, done := s.observe(ctx, "posts", "GetById")
+	post, err := s.next.Posts.GetById(ctx, id)
+	done(found(post != nil), err)
+	return post, err
+}
+
+func (s instrumentedPosts) Update(ctx context.Context, post *Post) error {
+	ctx, done := s.observe(ctx, "posts", "Update")
+	err := s.next.Posts.Update(ctx, post)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedPosts) Delete(ctx context.Context, id int64) error {
+	ctx, done := s.observe(ctx, "posts", "Delete")
+	err := s.next.Posts.Delete(ctx, id)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedPosts) GetUserFeed(ctx context.Context, userId int64, fq PaginatedFeedQuery) (*FeedPage, error) {
+	ctx, done := s.observe(ctx, "posts", "GetUserFeed")
+	page, err := s.next.Posts.GetUserFeed(ctx, userId, fq)
+	done(feedRows(page), err)
+	return page, err
+}
+
+func (s instrumentedPosts) DeleteAll(ctx context.Context) error {
+	ctx, done := s.observe(ctx, "posts", "DeleteAll")
+	err := s.next.Posts.DeleteAll(ctx)
+	done(-1, err)
+	return err
+}
+
+type instrumentedUsers struct{ *instrumented }
+
+func (s instrumentedUsers) GetById(ctx context.Context, id int64) (*User, error) {
+	ctx, done := s.observe(ctx, "users", "GetById")
+	user, err := s.next.Users.GetById(ctx, id)
+	done(found(user != nil), err)
+	return user, err
+}
+
+func (s instrumentedUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, done := s.observe(ctx, "users", "GetByEmail")
+	user, err := s.next.Users.GetByEmail(ctx, email)
+	done(found(user != nil), err)
+	return user, err
+}
+
+func (s instrumentedUsers) Create(ctx context.Context, tx *sql.Tx, user *User) error {
+	ctx, done := s.observe(ctx, "users", "Create")
+	err := s.next.Users.Create(ctx, tx, user)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) Update(ctx context.Context, user *User) error {
+	ctx, done := s.observe(ctx, "users", "Update")
+	err := s.next.Users.Update(ctx, user)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) CreateAndInvite(ctx context.Context, user *User, token string, expiresIn time.Duration, mails ...*MailJob) error {
+	ctx, done := s.observe(ctx, "users", "CreateAndInvite")
+	err := s.next.Users.CreateAndInvite(ctx, user, token, expiresIn, mails...)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) DeleteAll(ctx context.Context) error {
+	ctx, done := s.observe(ctx, "users", "DeleteAll")
+	err := s.next.Users.DeleteAll(ctx)
+	done(-1, err)
+	return err
+}
+
+func (s instrumentedUsers) Activate(ctx context.Context, token string) error {
+	ctx, done := s.observe(ctx, "users", "Activate")
+	err := s.next.Users.Activate(ctx, token)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) Delete(ctx context.Context, userId int64) (int64, error) {
+	ctx, done := s.observe(ctx, "users", "Delete")
+	n, err := s.next.Users.Delete(ctx, userId)
+	done(int(n), err)
+	return n, err
+}
+
+func (s instrumentedUsers) CreatePasswordReset(ctx context.Context, userId int64, token string, expiresIn time.Duration, mails ...*MailJob) error {
+	ctx, done := s.observe(ctx, "users", "CreatePasswordReset")
+	err := s.next.Users.CreatePasswordReset(ctx, userId, token, expiresIn, mails...)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	ctx, done := s.observe(ctx, "users", "ResetPassword")
+	err := s.next.Users.ResetPassword(ctx, token, newPassword)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) CreateEmailChange(ctx context.Context, userId int64, newEmail string, token string, expiresIn time.Duration, mails ...*MailJob) error {
+	ctx, done := s.observe(ctx, "users", "CreateEmailChange")
+	err := s.next.Users.CreateEmailChange(ctx, userId, newEmail, token, expiresIn, mails...)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) ConfirmEmailChange(ctx context.Context, token string) error {
+	ctx, done := s.observe(ctx, "users", "ConfirmEmailChange")
+	err := s.next.Users.ConfirmEmailChange(ctx, token)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedUsers) RotateInvitation(ctx context.Context, email string, token string, expiresIn time.Duration, cooldown time.Duration, mail func(*User) (*MailJob, error)) (*User, error) {
+	ctx, done := s.observe(ctx, "users", "RotateInvitation")
+	user, err := s.next.Users.RotateInvitation(ctx, email, token, expiresIn, cooldown, mail)
+	done(found(user != nil), err)
+	return user, err
+}
+
+func (s instrumentedUsers) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	ctx, done := s.observe(ctx, "users", "DeleteExpiredInvitations")
+	n, err := s.next.Users.DeleteExpiredInvitations(ctx)
+	done(int(n), err)
+	return n, err
+}
+
+func (s instrumentedUsers) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	ctx, done := s.observe(ctx, "users", "DeleteUnactivated")
+	n, err := s.next.Users.DeleteUnactivated(ctx, createdBefore)
+	done(int(n), err)
+	return n, err
+}
+
+type instrumentedComments struct{ *instrumented }
+
+func (s instrumentedComments) Create(ctx context.Context, comment *Comment) error {
+	ctx, done := s.observe(ctx, "comments", "Create")
+	err := s.next.Comments.Create(ctx, comment)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedComments) GetById(ctx context.Context, id int64) (*Comment, error) {
+	ctx, done := s.observe(ctx, "comments", "GetById")
+	comment, err := s.next.Comments.GetById(ctx, id)
+	done(found(comment != nil), err)
+	return comment, err
+}
+
+func (s instrumentedComments) GetByPostId(ctx context.Context, postId int64) ([]Comment, error) {
+	ctx, done := s.observe(ctx, "comments", "GetByPostId")
+	comments, err := s.next.Comments.GetByPostId(ctx, postId)
+	done(len(comments), err)
+	return comments, err
+}
+
+func (s instrumentedComments) GetThread(ctx context.Context, postId int64, parentId *int64, fq PaginatedCommentsQuery) ([]*Comment, error) {
+	ctx, done := s.observe(ctx, "comments", "GetThread")
+	comments, err := s.next.Comments.GetThread(ctx, postId, parentId, fq)
+	done(len(comments), err)
+	return comments, err
+}
+
+func (s instrumentedComments) Update(ctx context.Context, comment *Comment) error {
+	ctx, done := s.observe(ctx, "comments", "Update")
+	err := s.next.Comments.Update(ctx, comment)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedComments) Delete(ctx context.Context, id int64) error {
+	ctx, done := s.observe(ctx, "comments", "Delete")
+	err := s.next.Comments.Delete(ctx, id)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedComments) DeleteAll(ctx context.Context) error {
+	ctx, done := s.observe(ctx, "comments", "DeleteAll")
+	err := s.next.Comments.DeleteAll(ctx)
+	done(-1, err)
+	return err
+}
+
+type instrumentedFollowers struct{ *instrumented }
+
+func (s instrumentedFollowers) Follow(ctx context.Context, userToFollow int64, followingUser int64) error {
+	ctx, done := s.observe(ctx, "followers", "Follow")
+	err := s.next.Followers.Follow(ctx, userToFollow, followingUser)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedFollowers) Unfollow(ctx context.Context, userToUnfollow int64, unfollowingUser int64) (int64, error) {
+	ctx, done := s.observe(ctx, "followers", "Unfollow")
+	n, err := s.next.Followers.Unfollow(ctx, userToUnfollow, unfollowingUser)
+	done(int(n), err)
+	return n, err
+}
+
+type instrumentedRoles struct{ *instrumented }
+
+func (s instrumentedRoles) GetByName(ctx context.Context, roleName string) (*Role, error) {
+	ctx, done := s.observe(ctx, "roles", "GetByName")
+	role, err := s.next.Roles.GetByName(ctx, roleName)
+	done(found(role != nil), err)
+	return role, err
+}
+
+type instrumentedTokens struct{ *instrumented }
+
+func (s instrumentedTokens) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	ctx, done := s.observe(ctx, "tokens", "CreateRefreshToken")
+	err := s.next.Tokens.CreateRefreshToken(ctx, token)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTokens) RotateRefreshToken(ctx context.Context, hashedToken string, next *RefreshToken) error {
+	ctx, done := s.observe(ctx, "tokens", "RotateRefreshToken")
+	err := s.next.Tokens.RotateRefreshToken(ctx, hashedToken, next)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTokens) RevokeRefreshTokenFamily(ctx context.Context, userId int64, hashedToken string) (int64, error) {
+	ctx, done := s.observe(ctx, "tokens", "RevokeRefreshTokenFamily")
+	n, err := s.next.Tokens.RevokeRefreshTokenFamily(ctx, userId, hashedToken)
+	done(int(n), err)
+	return n, err
+}
+
+func (s instrumentedTokens) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) (int64, error) {
+	ctx, done := s.observe(ctx, "tokens", "RevokeAccessToken")
+	n, err := s.next.Tokens.RevokeAccessToken(ctx, jti, expiresAt)
+	done(int(n), err)
+	return n, err
+}
+
+func (s instrumentedTokens) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, done := s.observe(ctx, "tokens", "IsAccessTokenRevoked")
+	ok, err := s.next.Tokens.IsAccessTokenRevoked(ctx, jti)
+	done(affected(err), err)
+	return ok, err
+}
+
+func (s instrumentedTokens) DeleteExpiredRevocations(ctx context.Context) (int64, error) {
+	ctx, done := s.observe(ctx, "tokens", "DeleteExpiredRevocations")
+	n, err := s.next.Tokens.DeleteExpiredRevocations(ctx)
+	done(int(n), err)
+	return n, err
+}
+
+type instrumentedPersonalTokens struct{ *instrumented }
+
+func (s instrumentedPersonalTokens) Create(ctx context.Context, token *PersonalAccessToken) error {
+	ctx, done := s.observe(ctx, "personal_tokens", "Create")
+	err := s.next.PersonalTokens.Create(ctx, token)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedPersonalTokens) GetByUserId(ctx context.Context, userId int64) ([]PersonalAccessToken, error) {
+	ctx, done := s.observe(ctx, "personal_tokens", "GetByUserId")
+	tokens, err := s.next.PersonalTokens.GetByUserId(ctx, userId)
+	done(len(tokens), err)
+	return tokens, err
+}
+
+func (s instrumentedPersonalTokens) Authenticate(ctx context.Context, hashedToken string) (*PersonalAccessToken, error) {
+	ctx, done := s.observe(ctx, "personal_tokens", "Authenticate")
+	token, err := s.next.PersonalTokens.Authenticate(ctx, hashedToken)
+	done(found(token != nil), err)
+	return token, err
+}
+
+func (s instrumentedPersonalTokens) Delete(ctx context.Context, userId int64, tokenId int64) error {
+	ctx, done := s.observe(ctx, "personal_tokens", "Delete")
+	err := s.next.PersonalTokens.Delete(ctx, userId, tokenId)
+	done(affected(err), err)
+	return err
+}
+
+type instrumentedTwoFactor struct{ *instrumented }
+
+func (s instrumentedTwoFactor) Get(ctx context.Context, userId int64) (*TwoFactor, error) {
+	ctx, done := s.observe(ctx, "two_factor", "Get")
+	twoFactor, err := s.next.TwoFactor.Get(ctx, userId)
+	done(found(twoFactor != nil), err)
+	return twoFactor, err
+}
+
+func (s instrumentedTwoFactor) SetPendingSecret(ctx context.Context, userId int64, secret string) error {
+	ctx, done := s.observe(ctx, "two_factor", "SetPendingSecret")
+	err := s.next.TwoFactor.SetPendingSecret(ctx, userId, secret)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTwoFactor) Enable(ctx context.Context, userId int64, secret string, step int64, hashedRecoveryCodes []string) error {
+	ctx, done := s.observe(ctx, "two_factor", "Enable")
+	err := s.next.TwoFactor.Enable(ctx, userId, secret, step, hashedRecoveryCodes)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTwoFactor) Disable(ctx context.Context, userId int64) error {
+	ctx, done := s.observe(ctx, "two_factor", "Disable")
+	err := s.next.TwoFactor.Disable(ctx, userId)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTwoFactor) UseStep(ctx context.Context, userId int64, step int64) error {
+	ctx, done := s.observe(ctx, "two_factor", "UseStep")
+	err := s.next.TwoFactor.UseStep(ctx, userId, step)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedTwoFactor) UseRecoveryCode(ctx context.Context, userId int64, hashedCode string) error {
+	ctx, done := s.observe(ctx, "two_factor", "UseRecoveryCode")
+	err := s.next.TwoFactor.UseRecoveryCode(ctx, userId, hashedCode)
+	done(affected(err), err)
+	return err
+}
+
+type instrumentedLogins struct{ *instrumented }
+
+func (s instrumentedLogins) Record(ctx context.Context, event *LoginEvent) error {
+	ctx, done := s.observe(ctx, "logins", "Record")
+	err := s.next.Logins.Record(ctx, event)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedLogins) GetByUserId(ctx context.Context, userId int64, limit int) ([]LoginEvent, error) {
+	ctx, done := s.observe(ctx, "logins", "GetByUserId")
+	events, err := s.next.Logins.GetByUserId(ctx, userId, limit)
+	done(len(events), err)
+	return events, err
+}
+
+func (s instrumentedLogins) AccountFailures(ctx context.Context, email string, since time.Time) (int, time.Time, error) {
+	ctx, done := s.observe(ctx, "logins", "AccountFailures")
+	n, t, err := s.next.Logins.AccountFailures(ctx, email, since)
+	done(affected(err), err)
+	return n, t, err
+}
+
+func (s instrumentedLogins) IPFailures(ctx context.Context, ip string, since time.Time) (int, error) {
+	ctx, done := s.observe(ctx, "logins", "IPFailures")
+	n, err := s.next.Logins.IPFailures(ctx, ip, since)
+	done(affected(err), err)
+	return n, err
+}
+
+type instrumentedSearch struct{ *instrumented }
+
+func (s instrumentedSearch) Search(ctx context.Context, sq SearchQuery) ([]SearchResult, error) {
+	ctx, done := s.observe(ctx, "search", "Search")
+	results, err := s.next.Search.Search(ctx, sq)
+	done(len(results), err)
+	return results, err
+}
+
+type instrumentedMailOutbox struct{ *instrumented }
+
+func (s instrumentedMailOutbox) Enqueue(ctx context.Context, job *MailJob) error {
+	ctx, done := s.observe(ctx, "mail_outbox", "Enqueue")
+	err := s.next.MailOutbox.Enqueue(ctx, job)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedMailOutbox) Claim(ctx context.Context, lease time.Duration) (*MailJob, error) {
+	ctx, done := s.observe(ctx, "mail_outbox", "Claim")
+	job, err := s.next.MailOutbox.Claim(ctx, lease)
+	done(found(job != nil), err)
+	return job, err
+}
+
+func (s instrumentedMailOutbox) MarkSent(ctx context.Context, job *MailJob) error {
+	ctx, done := s.observe(ctx, "mail_outbox", "MarkSent")
+	err := s.next.MailOutbox.MarkSent(ctx, job)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedMailOutbox) Retry(ctx context.Context, job *MailJob, runAt time.Time, lastError string) error {
+	ctx, done := s.observe(ctx, "mail_outbox", "Retry")
+	err := s.next.MailOutbox.Retry(ctx, job, runAt, lastError)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedMailOutbox) DeadLetter(ctx context.Context, job *MailJob, lastError string) error {
+	ctx, done := s.observe(ctx, "mail_outbox", "DeadLetter")
+	err := s.next.MailOutbox.DeadLetter(ctx, job, lastError)
+	done(affected(err), err)
+	return err
+}
+
+func (s instrumentedMailOutbox) DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := s.observe(ctx, "mail_outbox", "DeleteFinishedBefore")
+	n, err := s.next.MailOutbox.DeleteFinishedBefore(ctx, before)
+	done(int(n), err)
+	return n, err
+}
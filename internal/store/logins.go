@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// login outcomes, the failures being the ones counted towards lockouts
+const (
+	LoginSuccess             = "success"
+	LoginTwoFactorRequired   = "two_factor_required"
+	LoginLockedOut           = "locked_out"
+	LoginUnknownEmail        = "unknown_email"
+	LoginInvalidPassword     = "invalid_password"
+	LoginInvalidSecondFactor = "invalid_second_factor"
+)
+
+var loginFailures = []string{LoginUnknownEmail, LoginInvalidPassword, LoginInvalidSecondFactor}
+
+type LoginEvent struct {
+	ID        int64  `json:"id"`
+	UserID    *int64 `json:"-"`
+	Email     string `json:"-"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Outcome   string `json:"outcome"`
+	CreatedAt string `json:"created_at"`
+}
+
+type LoginStore struct {
+	db *sql.DB
+}
+
+func (s *LoginStore) Record(ctx context.Context, event *LoginEvent) error {
+	query := `
+		INSERT INTO login_events (user_id, email, ip, user_agent, outcome) VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query,
+		event.UserID,
+		event.Email,
+		event.IP,
+		event.UserAgent,
+		event.Outcome,
+	).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (s *LoginStore) GetByUserId(ctx context.Context, userId int64, limit int) ([]LoginEvent, error) {
+	query := `
+		SELECT id, user_id, email, ip, user_agent, outcome, created_at
+		FROM login_events
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []LoginEvent{}
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Email, &e.IP, &e.UserAgent, &e.Outcome, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// AccountFailures counts the failed logins for an email since its last successful login (looking no further
+// back than since), along with the time of the latest one.
+func (s *LoginStore) AccountFailures(ctx context.Context, email string, since time.Time) (int, time.Time, error) {
+	query := `
+		SELECT count(*), max(created_at)
+		FROM login_events
+		WHERE email = $1 AND outcome = ANY($2) AND created_at > GREATEST($3, (
+			SELECT COALESCE(max(created_at), '-infinity') FROM login_events WHERE email = $1 AND outcome = $4
+		))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		count int
+		last  pq.NullTime
+	)
+	err := s.db.QueryRowContext(ctx, query, email, pq.Array(loginFailures), since, LoginSuccess).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, last.Time, nil
+}
+
+// IPFailures counts the failed logins from an IP since the given time, whatever the account
+func (s *LoginStore) IPFailures(ctx context.Context, ip string, since time.Time) (int, error) {
+	query := `SELECT count(*) FROM login_events WHERE ip = $1 AND outcome = ANY($2) AND created_at > $3`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, ip, pq.Array(loginFailures), since).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	MailPending = "pending"
+	MailSent    = "sent"
+	MailDead    = "dead"
+)
+
+// MailJob is an email waiting in the outbox, Data holding the template variables as JSON. LeasedUntil is
+// set by Claim, identifying the lease the job is completed under.
+type MailJob struct {
+	ID          int64
+	Template    string
+	Locale      string
+	Username    string
+	Email       string
+	Data        json.RawMessage
+	Sandbox     bool
+	Attempts    int
+	LastError   string
+	LeasedUntil time.Time
+}
+
+func NewMailJob(template, locale, username, email string, data any, sandbox bool) (*MailJob, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MailJob{
+		Template: template,
+		Locale:   locale,
+		Username: username,
+		Email:    email,
+		Data:     raw,
+		Sandbox:  sandbox,
+	}, nil
+}
+
+type MailOutboxStore struct {
+	db *sql.DB
+}
+
+func (s *MailOutboxStore) Enqueue(ctx context.Context, job *MailJob) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		return enqueueMail(ctx, tx, job)
+	})
+}
+
+// enqueueMail writes a job as part of a larger transaction, so that the email is sent if and only if it commits
+func enqueueMail(ctx context.Context, tx *sql.Tx, job *MailJob) error {
+	query := `
+		INSERT INTO mail_outbox (template, locale, username, email, data, sandbox) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(ctx, query,
+		job.Template,
+		job.Locale,
+		job.Username,
+		job.Email,
+		[]byte(job.Data),
+		job.Sandbox,
+	).Scan(&job.ID)
+}
+
+func enqueueMails(ctx context.Context, tx *sql.Tx, jobs []*MailJob) error {
+	for _, job := range jobs {
+		if err := enqueueMail(ctx, tx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim takes the oldest due job, hiding it from other workers for lease and counting the attempt.
+// A worker that dies mid-send leaves the job to be claimed again once the lease is over. It fails
+// with ErrorNotFound when no job is due. The job is then completed with MarkSent, Retry or DeadLetter,
+// which only apply while the lease it was claimed with is held.
+func (s *MailOutboxStore) Claim(ctx context.Context, lease time.Duration) (*MailJob, error) {
+	query := `
+		UPDATE mail_outbox SET run_at = $1, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM mail_outbox
+			WHERE status = $2 AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, template, locale, username, email, data, sandbox, attempts, last_error, run_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var (
+		job  = &MailJob{}
+		data []byte
+	)
+	err := s.db.QueryRowContext(ctx, query, time.Now().Add(lease), MailPending).Scan(
+		&job.ID,
+		&job.Template,
+		&job.Locale,
+		&job.Username,
+		&job.Email,
+		&data,
+		&job.Sandbox,
+		&job.Attempts,
+		&job.LastError,
+		&job.LeasedUntil,
+	)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	job.Data = data
+
+	return job, nil
+}
+
+// MarkSent records that the job was sent. Its variables are erased, as they hold the links of the email whose
+// tokens are only stored hashed otherwise. It fails with ErrorNotFound when the lease of the job is over,
+// another worker having claimed it again or the job being done.
+func (s *MailOutboxStore) MarkSent(ctx context.Context, job *MailJob) error {
+	query := `
+		UPDATE mail_outbox SET status = $1, sent_at = NOW(), last_error = '', data = '{}'
+		WHERE id = $2 AND status = $3 AND run_at = $4
+	`
+
+	return s.complete(ctx, query, MailSent, job.ID, MailPending, job.LeasedUntil)
+}
+
+// Retry puts a failed job back in the queue, due at runAt. It fails with ErrorNotFound when the lease of
+// the job is over, as MarkSent does.
+func (s *MailOutboxStore) Retry(ctx context.Context, job *MailJob, runAt time.Time, lastError string) error {
+	query := `
+		UPDATE mail_outbox SET run_at = $1, last_error = $2
+		WHERE id = $3 AND status = $4 AND run_at = $5
+	`
+
+	return s.complete(ctx, query, runAt, lastError, job.ID, MailPending, job.LeasedUntil)
+}
+
+// DeadLetter gives up on a job, keeping it with its last error for inspection, its variables being erased
+// as MarkSent does. It fails with ErrorNotFound when the lease of the job is over, as MarkSent does.
+func (s *MailOutboxStore) DeadLetter(ctx context.Context, job *MailJob, lastError string) error {
+	query := `
+		UPDATE mail_outbox SET status = $1, last_error = $2, data = '{}'
+		WHERE id = $3 AND status = $4 AND run_at = $5
+	`
+
+	return s.complete(ctx, query, MailDead, lastError, job.ID, MailPending, job.LeasedUntil)
+}
+
+// complete runs the update completing a claimed job, failing with ErrorNotFound if it updates nothing
+func (s *MailOutboxStore) complete(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+// DeleteFinishedBefore purges the jobs sent or given up on before the given time, returning how many there were.
+// Dead jobs are dated by their run_at, which is when their last attempt was claimed.
+func (s *MailOutboxStore) DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM mail_outbox
+		WHERE (status = $1 AND sent_at < $3) OR (status = $2 AND run_at < $3)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, MailSent, MailDead, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -1,20 +1,75 @@
 package store
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	ErrInvalidCursor     = errors.New("Invalid cursor")
+	ErrInvalidTimeWindow = errors.New("Invalid time window: until is before since")
+	ErrInvalidDepth      = fmt.Errorf("Invalid depth, at most %d levels of replies can be loaded", MaxCommentDepth)
+)
+
+// ParamError is a query param that can't be parsed
+type ParamError struct {
+	Name  string
+	Value string
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("Invalid %s: %q", e.Name, e.Value)
+}
+
 type PaginatedFeedQuery struct {
-	Limit  int      `json:"limit" validation:"gte=1,lte=20"`
-	Offset int      `json:"offset" validation:"gte=0"`
+	Limit  int      `json:"limit" validate:"gte=1,lte=20"`
+	Offset int      `json:"offset" validate:"gte=0"`
 	Sort   string   `json:"sort" validate:"oneof=asc desc"`
 	Tags   []string `json:"tags" validate:"max=5"`
 	Search string   `json:"search" validate:"max=100"`
 	Since  string   `json:"since"`
 	Until  string   `json:"until"`
+	// Cursor takes precedence over Offset when set
+	Cursor string `json:"cursor"`
+
+	cursor *FeedCursor
+}
+
+// FeedCursor points at the post a page starts after (or before, when Prev is set).
+// It travels as an opaque base64 string so clients don't depend on its shape.
+type FeedCursor struct {
+	CreatedAt string `json:"t"`
+	ID        int64  `json:"id"`
+	Prev      bool   `json:"p,omitempty"`
+}
+
+func (c FeedCursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeFeedCursor(s string) (*FeedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c FeedCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.CreatedAt == "" || c.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, c.CreatedAt); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
 }
 
 func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error) {
@@ -53,23 +108,128 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 		fq.Search = search
 	}
 
+	cursor := qs.Get("cursor")
+	if cursor != "" {
+		c, err := DecodeFeedCursor(cursor)
+		if err != nil {
+			return fq, err
+		}
+		fq.Cursor = cursor
+		fq.cursor = c
+		fq.Offset = 0
+	}
+
 	since := qs.Get("since")
 	if since != "" {
-		fq.Since = parseTime(since)
+		t, err := parseTime(since)
+		if err != nil {
+			return fq, &ParamError{Name: "since", Value: since}
+		}
+		fq.Since = t
 	}
 
 	until := qs.Get("until")
 	if until != "" {
-		fq.Until = parseTime(until)
+		t, err := parseTime(until)
+		if err != nil {
+			return fq, &ParamError{Name: "until", Value: until}
+		}
+		fq.Until = t
+	}
+
+	if fq.Since != "" && fq.Until != "" && fq.Until < fq.Since {
+		return fq, ErrInvalidTimeWindow
 	}
 
 	return fq, nil
 }
 
-func parseTime(since string) string {
-	t, err := time.Parse(time.DateTime, since)
-	if err != nil {
-		return ""
+// timeLayouts are the layouts accepted for time filters, a time without an offset being UTC
+var timeLayouts = []string{time.RFC3339, time.DateTime}
+
+// parseTime normalizes t to RFC 3339 in UTC, so that the result compares in time order
+func parseTime(t string) (string, error) {
+	for _, layout := range timeLayouts {
+		parsed, err := time.Parse(layout, t)
+		if err == nil {
+			return parsed.UTC().Format(time.RFC3339), nil
+		}
 	}
-	return t.Format(time.DateTime)
+	return "", fmt.Errorf("%q is neither RFC 3339 (2006-01-02T15:04:05Z07:00) nor %q", t, time.DateTime)
+}
+
+// PaginatedCommentsQuery pages through one level of a comment thread, Depth being
+// how many levels of replies to load under each comment (at most MaxCommentDepth)
+type PaginatedCommentsQuery struct {
+	Limit        int `json:"limit" validate:"gte=1,lte=50"`
+	Offset       int `json:"offset" validate:"gte=0"`
+	Depth        int `json:"depth" validate:"gte=0"`
+	RepliesLimit int `json:"replies_limit" validate:"gte=1,lte=20"`
+}
+
+func (fq PaginatedCommentsQuery) Parse(r *http.Request) (PaginatedCommentsQuery, error) {
+	qs := r.URL.Query()
+
+	// parsed in order, so that the first invalid param reported is always the same one
+	params := []struct {
+		name string
+		dst  *int
+	}{
+		{"limit", &fq.Limit},
+		{"offset", &fq.Offset},
+		{"depth", &fq.Depth},
+		{"replies_limit", &fq.RepliesLimit},
+	}
+
+	for _, param := range params {
+		val := qs.Get(param.name)
+		if val == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fq, &ParamError{Name: param.name, Value: val}
+		}
+		*param.dst = n
+	}
+
+	// checked here rather than with a tag, so that the bound follows MaxCommentDepth
+	if fq.Depth > MaxCommentDepth {
+		return fq, ErrInvalidDepth
+	}
+
+	return fq, nil
+}
+
+type SearchQuery struct {
+	Query  string `json:"q" validate:"required,max=100"`
+	Limit  int    `json:"limit" validate:"gte=1,lte=50"`
+	Offset int    `json:"offset" validate:"gte=0"`
+}
+
+func (sq SearchQuery) Parse(r *http.Request) (SearchQuery, error) {
+	qs := r.URL.Query()
+
+	sq.Query = strings.TrimSpace(qs.Get("q"))
+
+	limit := qs.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return sq, &ParamError{Name: "limit", Value: limit}
+		}
+		sq.Limit = l
+	}
+
+	offset := qs.Get("offset")
+	if offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil {
+			return sq, &ParamError{Name: "offset", Value: offset}
+		}
+		sq.Offset = o
+	}
+
+	return sq, nil
 }
@@ -0,0 +1,17 @@
+package store
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaginatedCommentsQueryReportsTheFirstInvalidParam(t *testing.T) {
+	r := httptest.NewRequest("GET", "/?replies_limit=x&depth=y&offset=z&limit=w", nil)
+
+	for range 20 {
+		_, err := PaginatedCommentsQuery{}.Parse(r)
+		if err == nil || err.Error() != `Invalid limit: "w"` {
+			t.Fatalf("Parse = %v, want the error of limit", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken is a long-lived, scoped token users mint for bots and scripts
+type PersonalAccessToken struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Token      string    `json:"-"` // sha256 hash of the token handed to the user
+	Scopes     []string  `json:"scopes"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt *string   `json:"last_used_at"`
+	CreatedAt  string    `json:"created_at"`
+}
+
+type PersonalTokenStore struct {
+	db *sql.DB
+}
+
+func (s *PersonalTokenStore) Create(ctx context.Context, token *PersonalAccessToken) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token, scopes, expires_at)
+		VALUES ($1,$2,$3,$4,$5) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query,
+		token.UserID,
+		token.Name,
+		token.Token,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (s *PersonalTokenStore) GetByUserId(ctx context.Context, userId int64) ([]PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []PersonalAccessToken{}
+	for rows.Next() {
+		var t PersonalAccessToken
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Authenticate looks up an unexpired token by its hash and records that it was used
+func (s *PersonalTokenStore) Authenticate(ctx context.Context, hashedToken string) (*PersonalAccessToken, error) {
+	query := `
+		UPDATE personal_access_tokens SET last_used_at = NOW()
+		WHERE token = $1 AND expires_at > NOW()
+		RETURNING id, user_id, name, scopes, expires_at, last_used_at, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var t PersonalAccessToken
+	err := s.db.QueryRowContext(ctx, query, hashedToken).Scan(
+		&t.ID, &t.UserID, &t.Name, pq.Array(&t.Scopes), &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+func (s *PersonalTokenStore) Delete(ctx context.Context, userId, tokenId int64) error {
+	query := `DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, tokenId, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
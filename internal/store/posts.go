@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/lib/pq"
 )
@@ -26,6 +27,13 @@ type FeedPost struct {
 	CommentsCount int `json:"comments_count"`
 }
 
+// FeedPage is a page of the feed along with the cursors of its neighbouring pages, empty when there is none
+type FeedPage struct {
+	Posts      []FeedPost
+	NextCursor string
+	PrevCursor string
+}
+
 type PostStore struct {
 	db *sql.DB
 }
@@ -127,7 +135,34 @@ func (s *PostStore) Delete(ctx context.Context, postId int64) error {
 	return nil
 }
 
-func (s *PostStore) GetUserFeed(ctx context.Context, userId int64, fq PaginatedFeedQuery) ([]FeedPost, error) {
+func (s *PostStore) GetUserFeed(ctx context.Context, userId int64, fq PaginatedFeedQuery) (*FeedPage, error) {
+	args := []any{userId, fq.Limit + 1, fq.Offset, fq.Search, pq.Array(fq.Tags)}
+
+	// paging backwards walks the feed in the opposite order, the rows get flipped back below
+	sort := fq.Sort
+	if fq.cursor != nil && fq.cursor.Prev {
+		sort = oppositeSort(sort)
+	}
+
+	filters := ""
+	if fq.Since != "" {
+		args = append(args, fq.Since)
+		filters += fmt.Sprintf(" AND p.created_at >= $%d::timestamptz", len(args))
+	}
+	if fq.Until != "" {
+		args = append(args, fq.Until)
+		filters += fmt.Sprintf(" AND p.created_at < $%d::timestamptz", len(args))
+	}
+
+	if fq.cursor != nil {
+		op := "<"
+		if sort == "asc" {
+			op = ">"
+		}
+		args = append(args, fq.cursor.CreatedAt, fq.cursor.ID)
+		filters += fmt.Sprintf(" AND (p.created_at, p.id) %s ($%d::timestamptz, $%d)", op, len(args)-1, len(args))
+	}
+
 	query := `
 		    select p.id,p.user_id,p.title,p.content,p.created_at,p.version,p.tags,u.username, count(c.id) as comments_count
 		    from posts p
@@ -138,15 +173,16 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userId int64, fq PaginatedF
 						f.user_id = $1 AND
 						(p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%') AND
 						(p.tags @> $5 OR $5 = '{}')
+						` + filters + `
 		    GROUP by p.id, u.id
-		    ORDER by p.created_at ` + fq.Sort + `
+		    ORDER by p.created_at ` + sort + `, p.id ` + sort + `
 		    LIMIT $2 offset $3
     `
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, userId, fq.Limit, fq.Offset, fq.Search, pq.Array(fq.Tags))
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -178,5 +214,47 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userId int64, fq PaginatedF
 		return nil, err
 	}
 
-	return feedPosts, nil
+	// we asked for one extra row to know whether there is anything past this page
+	hasMore := len(feedPosts) > fq.Limit
+	if hasMore {
+		feedPosts = feedPosts[:fq.Limit]
+	}
+
+	page := &FeedPage{Posts: feedPosts}
+	if len(feedPosts) == 0 {
+		return page, nil
+	}
+
+	if fq.cursor != nil && fq.cursor.Prev {
+		for i, j := 0, len(feedPosts)-1; i < j; i, j = i+1, j-1 {
+			feedPosts[i], feedPosts[j] = feedPosts[j], feedPosts[i]
+		}
+
+		// coming back from a later page, so there is always a next one
+		page.NextCursor = cursorAt(feedPosts[len(feedPosts)-1], false)
+		if hasMore {
+			page.PrevCursor = cursorAt(feedPosts[0], true)
+		}
+		return page, nil
+	}
+
+	if hasMore {
+		page.NextCursor = cursorAt(feedPosts[len(feedPosts)-1], false)
+	}
+	if fq.cursor != nil || fq.Offset > 0 {
+		page.PrevCursor = cursorAt(feedPosts[0], true)
+	}
+
+	return page, nil
+}
+
+func cursorAt(p FeedPost, prev bool) string {
+	return FeedCursor{CreatedAt: p.CreatedAt, ID: p.ID, Prev: prev}.Encode()
+}
+
+func oppositeSort(sort string) string {
+	if sort == "asc" {
+		return "desc"
+	}
+	return "asc"
 }
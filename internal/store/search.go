@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const (
+	SearchResultPost    = "post"
+	SearchResultComment = "comment"
+	SearchResultUser    = "user"
+)
+
+// SearchResult is a post, comment or user matching a search, told apart by Type.
+// Title and Snippet are HTML, the text being escaped and the matched terms wrapped in <mark></mark>.
+// Rank goes from 0 to 1, the best match of each type ranking 1.
+type SearchResult struct {
+	Type      string  `json:"type"`
+	ID        int64   `json:"id"`
+	PostID    *int64  `json:"post_id,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Snippet   string  `json:"snippet,omitempty"`
+	Rank      float64 `json:"rank"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type SearchStore struct {
+	db *sql.DB
+}
+
+func (s *SearchStore) Search(ctx context.Context, sq SearchQuery) ([]SearchResult, error) {
+	// ranking happens over the whole union, the (expensive) headlines only for the page being returned.
+	// Full-text ranks and username similarities aren't on the same scale, so each score is divided by the
+	// best one of its type. The text is escaped before being highlighted, the headlines being HTML.
+	query := `
+		WITH q AS (
+			SELECT websearch_to_tsquery('english', $1) AS query
+		), matches AS (
+			SELECT 'post' AS type, p.id, p.id AS post_id, p.title, p.content AS body,
+				ts_rank(p.search_vector, q.query) AS score, p.created_at
+			FROM posts p, q
+			WHERE p.search_vector @@ q.query
+			UNION ALL
+			SELECT 'comment', c.id, c.post_id, '', c.content,
+				ts_rank(c.search_vector, q.query), c.created_at
+			FROM comments c, q
+			WHERE c.search_vector @@ q.query
+			UNION ALL
+			SELECT 'user', u.id, NULL, u.username, '',
+				similarity(u.username, $1), u.created_at
+			FROM users u
+			WHERE u.is_active = true AND (u.username % $1 OR u.username ILIKE $1 || '%')
+		), hits AS (
+			SELECT m.type, m.id, m.post_id, m.title, m.body, m.created_at,
+				COALESCE(m.score / NULLIF(max(m.score) OVER (PARTITION BY m.type), 0), 0) AS rank
+			FROM matches m
+			ORDER BY rank DESC, created_at DESC
+			LIMIT $2 OFFSET $3
+		)
+		SELECT h.type, h.id, h.post_id,
+			CASE WHEN h.type = 'post' THEN ts_headline('english', e.title, q.query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') ELSE e.title END,
+			CASE WHEN h.type = 'user' THEN '' ELSE ts_headline('english', e.body, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10') END,
+			h.rank, h.created_at
+		FROM hits h, q,
+			LATERAL (SELECT ` + htmlEscapeSQL("h.title") + ` AS title, ` + htmlEscapeSQL("h.body") + ` AS body) e
+		ORDER BY h.rank DESC, h.created_at DESC;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, sq.Query, sq.Limit, sq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		err := rows.Scan(&r.Type, &r.ID, &r.PostID, &r.Title, &r.Snippet, &r.Rank, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// htmlEscapeSQL escapes the HTML special characters of a text column in SQL, as html.EscapeString does
+func htmlEscapeSQL(column string) string {
+	return fmt.Sprintf(`replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`, column)
+}
@@ -13,48 +13,112 @@ type Storage struct {
 		GetById(context.Context, int64) (*Post, error)
 		Update(context.Context, *Post) error
 		Delete(context.Context, int64) error
-		GetUserFeed(context.Context, int64, PaginatedFeedQuery) ([]FeedPost, error)
+		GetUserFeed(context.Context, int64, PaginatedFeedQuery) (*FeedPage, error)
 		DeleteAll(context.Context) error
 	}
 	Users interface {
 		GetById(context.Context, int64) (*User, error)
 		GetByEmail(context.Context, string) (*User, error)
 		Create(context.Context, *sql.Tx, *User) error
-		CreateAndInvite(ctx context.Context, user *User, token string, expiresIn time.Duration) error
+		Update(context.Context, *User) error
+		CreateAndInvite(ctx context.Context, user *User, token string, expiresIn time.Duration, mails ...*MailJob) error
 		DeleteAll(context.Context) error
 		Activate(ctx context.Context, token string) error
-		Delete(ctx context.Context, userId int64) error
+		Delete(ctx context.Context, userId int64) (int64, error)
+		CreatePasswordReset(ctx context.Context, userId int64, token string, expiresIn time.Duration, mails ...*MailJob) error
+		ResetPassword(ctx context.Context, token string, newPassword string) error
+		CreateEmailChange(ctx context.Context, userId int64, newEmail string, token string, expiresIn time.Duration, mails ...*MailJob) error
+		ConfirmEmailChange(ctx context.Context, token string) error
+		RotateInvitation(ctx context.Context, email string, token string, expiresIn time.Duration, cooldown time.Duration, mail func(*User) (*MailJob, error)) (*User, error)
+		DeleteExpiredInvitations(ctx context.Context) (int64, error)
+		DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error)
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
+		GetById(context.Context, int64) (*Comment, error)
 		GetByPostId(context.Context, int64) ([]Comment, error)
+		GetThread(ctx context.Context, postId int64, parentId *int64, fq PaginatedCommentsQuery) ([]*Comment, error)
+		Update(context.Context, *Comment) error
+		Delete(context.Context, int64) error
 		DeleteAll(context.Context) error
 	}
 	Followers interface {
 		Follow(ctx context.Context, userToFollow int64, followingUser int64) error
-		Unfollow(ctx context.Context, userToUnfollow int64, unfollowingUser int64) error
+		Unfollow(ctx context.Context, userToUnfollow int64, unfollowingUser int64) (int64, error)
 	}
 
 	Roles interface {
 		GetByName(ctx context.Context, roleName string) (*Role, error)
 	}
+	Tokens interface {
+		CreateRefreshToken(context.Context, *RefreshToken) error
+		RotateRefreshToken(ctx context.Context, hashedToken string, next *RefreshToken) error
+		RevokeRefreshTokenFamily(ctx context.Context, userId int64, hashedToken string) (int64, error)
+		RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) (int64, error)
+		IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+		DeleteExpiredRevocations(context.Context) (int64, error)
+	}
+	PersonalTokens interface {
+		Create(context.Context, *PersonalAccessToken) error
+		GetByUserId(context.Context, int64) ([]PersonalAccessToken, error)
+		Authenticate(ctx context.Context, hashedToken string) (*PersonalAccessToken, error)
+		Delete(ctx context.Context, userId, tokenId int64) error
+	}
+	TwoFactor interface {
+		Get(ctx context.Context, userId int64) (*TwoFactor, error)
+		SetPendingSecret(ctx context.Context, userId int64, secret string) error
+		Enable(ctx context.Context, userId int64, secret string, step int64, hashedRecoveryCodes []string) error
+		Disable(ctx context.Context, userId int64) error
+		UseStep(ctx context.Context, userId int64, step int64) error
+		UseRecoveryCode(ctx context.Context, userId int64, hashedCode string) error
+	}
+	Logins interface {
+		Record(context.Context, *LoginEvent) error
+		GetByUserId(ctx context.Context, userId int64, limit int) ([]LoginEvent, error)
+		AccountFailures(ctx context.Context, email string, since time.Time) (int, time.Time, error)
+		IPFailures(ctx context.Context, ip string, since time.Time) (int, error)
+	}
+	Search interface {
+		Search(context.Context, SearchQuery) ([]SearchResult, error)
+	}
+	MailOutbox interface {
+		Enqueue(context.Context, *MailJob) error
+		Claim(ctx context.Context, lease time.Duration) (*MailJob, error)
+		MarkSent(ctx context.Context, job *MailJob) error
+		Retry(ctx context.Context, job *MailJob, runAt time.Time, lastError string) error
+		DeadLetter(ctx context.Context, job *MailJob, lastError string) error
+		DeleteFinishedBefore(ctx context.Context, before time.Time) (int64, error)
+	}
 }
 
+// SchemaVersion is the last migration the store is written against, to bump with every new migration
+const SchemaVersion = 27
+
 var (
 	ErrorNotFound        = errors.New("Record not found.")
 	QueryTimeoutDuration = time.Second * 5
 	ErrConflict          = errors.New("Resource already exists")
 	ErrDuplicateEmail    = errors.New("Email already in use")
 	ErrDuplicateUsername = errors.New("Username already in use")
+	ErrCommentTooDeep    = errors.New("Comment thread is too deep")
+	ErrTokenExpired      = errors.New("Token has expired")
+	ErrTokenReused       = errors.New("Token was already used")
+	ErrTooSoon           = errors.New("Too soon, try again later")
 )
 
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
-		Posts:     &PostStore{db},
-		Users:     &UserStore{db},
-		Comments:  &CommentStore{db},
-		Followers: &FollowerStore{db},
-		Roles:     &RolesStore{db},
+		Posts:          &PostStore{db},
+		Users:          &UserStore{db},
+		Comments:       &CommentStore{db},
+		Followers:      &FollowerStore{db},
+		Roles:          &RolesStore{db},
+		Tokens:         &TokenStore{db},
+		PersonalTokens: &PersonalTokenStore{db},
+		TwoFactor:      &TwoFactorStore{db},
+		Logins:         &LoginStore{db},
+		Search:         &SearchStore{db},
+		MailOutbox:     &MailOutboxStore{db},
 	}
 }
 
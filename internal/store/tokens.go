@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RefreshToken is a single use token exchanged for a new access token. Every rotation
+// hands out a new token of the same family, so that a stolen token that gets replayed
+// after it was rotated can take the whole family down with it.
+type RefreshToken struct {
+	ID        int64
+	UserID    int64
+	FamilyID  string
+	Token     string // sha256 hash of the token handed to the client
+	ExpiresAt time.Time
+}
+
+type TokenStore struct {
+	db *sql.DB
+}
+
+func (s *TokenStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (token, user_id, family_id, expires_at) VALUES ($1,$2,$3,$4) RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(ctx, query, token.Token, token.UserID, token.FamilyID, token.ExpiresAt).Scan(&token.ID)
+}
+
+// RotateRefreshToken revokes the refresh token matching hashedToken and stores next in its family.
+// Presenting a token that was already rotated revokes its whole family and returns ErrTokenReused.
+func (s *TokenStore) RotateRefreshToken(ctx context.Context, hashedToken string, next *RefreshToken) error {
+	reused := false
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var (
+			current   RefreshToken
+			revokedAt sql.NullTime
+		)
+		err := tx.QueryRowContext(ctx,
+			`SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1 FOR UPDATE`,
+			hashedToken,
+		).Scan(&current.ID, &current.UserID, &current.FamilyID, &current.ExpiresAt, &revokedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+
+		if revokedAt.Valid {
+			reused = true
+			return s.revokeFamily(ctx, tx, current.FamilyID)
+		}
+
+		if time.Now().After(current.ExpiresAt) {
+			return ErrTokenExpired
+		}
+
+		next.UserID = current.UserID
+		next.FamilyID = current.FamilyID
+		err = tx.QueryRowContext(ctx,
+			`INSERT INTO refresh_tokens (token, user_id, family_id, expires_at) VALUES ($1,$2,$3,$4) RETURNING id`,
+			next.Token, next.UserID, next.FamilyID, next.ExpiresAt,
+		).Scan(&next.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`,
+			next.ID, current.ID,
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the family revocation has to be committed before reporting the reuse
+	if reused {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every token in the family of the refresh token matching hashedToken,
+// as long as it belongs to userId, returning how many were still in use.
+func (s *TokenStore) RevokeRefreshTokenFamily(ctx context.Context, userId int64, hashedToken string) (int64, error) {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND family_id = (
+			SELECT family_id FROM refresh_tokens WHERE token = $1 AND user_id = $2
+		)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, hashedToken, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *TokenStore) revokeFamily(ctx context.Context, tx *sql.Tx, familyId string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, familyId)
+	return err
+}
+
+// RevokeAccessToken adds the jti of an access token to the revocation list until the token expires on its own,
+// returning 0 if it was already there
+func (s *TokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) (int64, error) {
+	query := `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1,$2) ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, jti, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *TokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked)
+	return revoked, err
+}
+
+// DeleteExpiredRevocations removes the revoked access tokens that expired since, which are rejected anyway,
+// returning how many there were
+func (s *TokenStore) DeleteExpiredRevocations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM revoked_tokens WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
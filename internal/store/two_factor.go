@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// TwoFactor is the TOTP state of a user. The secret is set as soon as enrolment starts,
+// but only checked at login once a first code confirmed it and Enabled is set.
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, userId int64) (*TwoFactor, error) {
+	query := `SELECT COALESCE(totp_secret, ''), totp_enabled, totp_last_step FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	tf := &TwoFactor{}
+	err := s.db.QueryRowContext(ctx, query, userId).Scan(&tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return tf, nil
+}
+
+// SetPendingSecret starts (or restarts) enrolment, it doesn't touch users who already have 2FA enabled
+func (s *TwoFactorStore) SetPendingSecret(ctx context.Context, userId int64, secret string) error {
+	query := `UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_enabled = false`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, secret, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// Enable turns 2FA on with the secret and the step of the code that confirmed it, replacing any previous
+// recovery codes. It fails with ErrConflict if 2FA was enabled, or enrolment restarted with another secret,
+// since the code was checked.
+func (s *TwoFactorStore) Enable(ctx context.Context, userId int64, secret string, step int64, hashedRecoveryCodes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE id = $2 AND totp_secret = $3 AND totp_enabled = false`,
+			step, userId, secret,
+		)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rows == 0 {
+			return ErrConflict
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId); err != nil {
+			return err
+		}
+
+		for _, code := range hashedRecoveryCodes {
+			_, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code) VALUES ($1,$2)`, userId, code)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *TwoFactorStore) Disable(ctx context.Context, userId int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0 WHERE id = $1`,
+			userId,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId)
+		return err
+	})
+}
+
+// UseStep records the step of an accepted code, failing with ErrTokenReused if it, or a later one, was already used
+func (s *TwoFactorStore) UseStep(ctx context.Context, userId int64, step int64) error {
+	query := `UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, step, userId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTokenReused
+	}
+
+	return nil
+}
+
+// UseRecoveryCode burns an unused recovery code, ErrorNotFound meaning there is no such unused code
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userId int64, hashedCode string) error {
+	query := `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userId, hashedCode)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
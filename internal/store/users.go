@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -19,6 +20,21 @@ type User struct {
 	CreatedAt string   `json:"created_at"`
 	RoleID    int64    `json:"role_id"`
 	Role      Role     `json:"role"`
+	Profile
+	// Locale is the preferred locale of the user, empty when it has none
+	Locale  string `json:"locale"`
+	Version int    `json:"version"`
+	// PasswordChangedAt invalidates the access tokens issued before it
+	PasswordChangedAt time.Time `json:"-"`
+}
+
+// Profile is the public, user editable part of a user
+type Profile struct {
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+	AvatarURL   string `json:"avatar_url"`
 }
 
 type password struct {
@@ -26,6 +42,9 @@ type password struct {
 	hash []byte
 }
 
+// dummyHash is compared against when there is no hash, so that unknown users take as long to reject as known ones
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("go-social dummy password"), bcrypt.DefaultCost)
+
 func (p *password) Set(text string) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
 	if err != nil {
@@ -36,6 +55,16 @@ func (p *password) Set(text string) error {
 	return nil
 }
 
+// Compare reports whether text matches the password, always taking the time of a bcrypt comparison
+func (p *password) Compare(text string) bool {
+	if len(p.hash) == 0 {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(text))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(p.hash, []byte(text)) == nil
+}
+
 type UserStore struct {
 	db *sql.DB
 }
@@ -45,18 +74,20 @@ func (s *UserStore) DeleteAll(ctx context.Context) error {
 	return err
 }
 
+// Create inserts the user as part of tx, which it is up to the caller to commit
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	query := `
-		INSERT INTO users(username,email,password,role_id)
-		VALUES ($1,$2,$3,(SELECT id FROM roles WHERE name = $4))
+		INSERT INTO users(username,email,password,locale,role_id)
+		VALUES ($1,$2,$3,$4,(SELECT id FROM roles WHERE name = $5))
 		RETURNING id,email,created_at,role_id
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
-	err := s.db.QueryRowContext(ctx, query,
+	err := tx.QueryRowContext(ctx, query,
 		user.Username,
 		user.Email,
 		user.Password.hash,
+		user.Locale,
 		user.Role.Name,
 	).Scan(&user.ID, &user.Email, &user.CreatedAt, &user.RoleID)
 
@@ -73,9 +104,47 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+// Update saves the username and profile of a user, as long as nobody changed them since user.Version was read
+func (s *UserStore) Update(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET username = $1, display_name = $2, bio = $3, location = $4, website = $5, avatar_url = $6, locale = $7,
+			version = version + 1
+		WHERE id = $8 AND version = $9
+		RETURNING version
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query,
+		user.Username,
+		user.DisplayName,
+		user.Bio,
+		user.Location,
+		user.Website,
+		user.AvatarURL,
+		user.Locale,
+		user.ID,
+		user.Version,
+	).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *UserStore) GetById(ctx context.Context, userId int64) (*User, error) {
 	query := `
-		SELECT users.id,username,email,password,created_at, roles.*
+		SELECT users.id,username,email,password,created_at,is_active,password_changed_at,
+			display_name,bio,location,website,avatar_url,locale,version, roles.*
 		FROM users
 		JOIN roles ON (users.role_id = roles.id)
 		WHERE users.id = $1;
@@ -86,7 +155,26 @@ func (s *UserStore) GetById(ctx context.Context, userId int64) (*User, error) {
 	user := &User{}
 	err := s.db.QueryRowContext(ctx, query,
 		userId,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Role.ID, &user.Role.Name, &user.Role.Description, &user.Role.Level)
+	).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.Password.hash,
+		&user.CreatedAt,
+		&user.IsActive,
+		&user.PasswordChangedAt,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Location,
+		&user.Website,
+		&user.AvatarURL,
+		&user.Locale,
+		&user.Version,
+		&user.Role.ID,
+		&user.Role.Name,
+		&user.Role.Description,
+		&user.Role.Level,
+	)
 
 	if err != nil {
 		switch err {
@@ -102,7 +190,7 @@ func (s *UserStore) GetById(ctx context.Context, userId int64) (*User, error) {
 
 func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id,username,email,password,created_at FROM users WHERE email = $1 AND is_active = true;
+		SELECT id,username,email,password,created_at,locale FROM users WHERE email = $1 AND is_active = true;
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -110,7 +198,7 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	user := &User{}
 	err := s.db.QueryRowContext(ctx, query,
 		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Locale)
 
 	if err != nil {
 		switch err {
@@ -124,7 +212,8 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	return user, nil
 }
 
-func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, expiresIn time.Duration) error {
+// CreateAndInvite creates the user and its invitation, queuing the given emails in the same transaction
+func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token string, expiresIn time.Duration, mails ...*MailJob) error {
 	return withTx(s.db, ctx, func(tx *sql.Tx) error {
 		// create the user
 		if err := s.Create(ctx, tx, user); err != nil {
@@ -135,7 +224,7 @@ func (s *UserStore) CreateAndInvite(ctx context.Context, user *User, token strin
 			return err
 		}
 
-		return nil
+		return enqueueMails(ctx, tx, mails)
 	})
 }
 
@@ -162,8 +251,110 @@ func (s *UserStore) Activate(ctx context.Context, token string) error {
 	})
 }
 
+// RotateInvitation replaces the invitation of the inactive account with the given email by a new one, queuing
+// the email mail builds for the account in the same transaction. It fails with ErrorNotFound if there is no such
+// account, and with ErrTooSoon if the current invitation is younger than cooldown.
+func (s *UserStore) RotateInvitation(ctx context.Context, email string, token string, expiresIn time.Duration, cooldown time.Duration, mail func(*User) (*MailJob, error)) (*User, error) {
+	user := &User{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// locking the user serializes concurrent resends
+		err := tx.QueryRowContext(ctx,
+			`SELECT id, username, email, created_at, locale FROM users WHERE email = $1 AND is_active = false FOR UPDATE`,
+			email,
+		).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.Locale)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+
+		var recent bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM user_invitations WHERE user_id = $1 AND created_at > $2)`,
+			user.ID, time.Now().Add(-cooldown),
+		).Scan(&recent)
+		if err != nil {
+			return err
+		}
+		if recent {
+			return ErrTooSoon
+		}
+
+		if err := s.deleteUserInvitations(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := s.createUserInvitation(ctx, tx, token, expiresIn, user.ID); err != nil {
+			return err
+		}
+
+		job, err := mail(user)
+		if err != nil {
+			return err
+		}
+		return enqueueMail(ctx, tx, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// DeleteExpiredInvitations removes the invitations that can no longer be used, returning how many there were
+func (s *UserStore) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitations WHERE expiresin < $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteUnactivated removes the accounts created before createdBefore that were never activated, along with their
+// invitations, returning how many accounts there were
+func (s *UserStore) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	var deleted int64
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// user_invitations has no foreign key, so the invitations don't go away with the users
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM user_invitations
+			WHERE user_id IN (SELECT id FROM users WHERE is_active = false AND created_at < $1)
+		`, createdBefore)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE is_active = false AND created_at < $1`, createdBefore)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = res.RowsAffected()
+		return err
+	})
+
+	return deleted, err
+}
+
 func (s *UserStore) update(ctx context.Context, tx *sql.Tx, user *User) error {
-	query := `UPDATE users SET username = $1, email = $2, is_active = $3 WHERE id = $4`
+	query := `UPDATE users SET username = $1, email = $2, is_active = $3, version = version + 1 WHERE id = $4`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -229,9 +420,12 @@ func (s *UserStore) deleteUserInvitations(ctx context.Context, tx *sql.Tx, userI
 	return nil
 }
 
-func (s *UserStore) Delete(ctx context.Context, userId int64) error {
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
-		if err := s.delete(ctx, tx, userId); err != nil {
+// Delete deletes the user with its invitations, returning 0 if there was no such user
+func (s *UserStore) Delete(ctx context.Context, userId int64) (int64, error) {
+	var deleted int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		if deleted, err = s.delete(ctx, tx, userId); err != nil {
 			return err
 		}
 
@@ -240,17 +434,174 @@ func (s *UserStore) Delete(ctx context.Context, userId int64) error {
 		}
 		return nil
 	})
+
+	return deleted, err
 }
 
-func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, userId int64) error {
+func (s *UserStore) delete(ctx context.Context, tx *sql.Tx, userId int64) (int64, error) {
 	query := `DELETE FROM users WHERE id = $1`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := tx.ExecContext(ctx, query, userId)
+	res, err := tx.ExecContext(ctx, query, userId)
 	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// CreatePasswordReset stores a reset token for the user, replacing any previous one, and queues the given emails
+func (s *UserStore) CreatePasswordReset(ctx context.Context, userId int64, token string, expiresIn time.Duration, mails ...*MailJob) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deletePasswordResets(ctx, tx, userId); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO password_resets (token, user_id, expires_at) VALUES ($1,$2,$3)`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, token, userId, time.Now().Add(expiresIn)); err != nil {
+			return err
+		}
+
+		return enqueueMails(ctx, tx, mails)
+	})
+}
+
+// ResetPassword sets a new password for the owner of an unexpired reset token, then burns the token
+// and revokes every session of the user.
+func (s *UserStore) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	var p password
+	if err := p.Set(newPassword); err != nil {
 		return err
 	}
 
-	return nil
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var userId int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id FROM password_resets WHERE token = $1 AND expires_at > $2 FOR UPDATE`,
+			token, time.Now(),
+		).Scan(&userId)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE users SET password = $1, password_changed_at = NOW() WHERE id = $2`,
+			p.hash, userId,
+		)
+		if err != nil {
+			return err
+		}
+
+		if err := s.deletePasswordResets(ctx, tx, userId); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+			userId,
+		)
+		return err
+	})
+}
+
+func (s *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userId int64) error {
+	query := `DELETE FROM password_resets WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userId)
+	return err
+}
+
+// CreateEmailChange stores a pending change to newEmail, replacing any previous one, and queues the given emails.
+// It fails with ErrDuplicateEmail if the address already belongs to an account.
+func (s *UserStore) CreateEmailChange(ctx context.Context, userId int64, newEmail string, token string, expiresIn time.Duration, mails ...*MailJob) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, newEmail).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicateEmail
+		}
+
+		if err := s.deleteEmailChanges(ctx, tx, userId); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO email_changes (token, user_id, new_email, expires_at) VALUES ($1,$2,$3,$4)`,
+			token, userId, newEmail, time.Now().Add(expiresIn),
+		)
+		if err != nil {
+			return err
+		}
+
+		return enqueueMails(ctx, tx, mails)
+	})
+}
+
+// ConfirmEmailChange switches the owner of an unexpired email change token to its new email, then burns the token
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		var (
+			userId   int64
+			newEmail string
+		)
+		err := tx.QueryRowContext(ctx,
+			`SELECT user_id, new_email FROM email_changes WHERE token = $1 AND expires_at > $2 FOR UPDATE`,
+			token, time.Now(),
+		).Scan(&userId, &newEmail)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+
+		// the address may have been taken since the change was requested
+		_, err = tx.ExecContext(ctx, `UPDATE users SET email = $1, version = version + 1 WHERE id = $2`, newEmail, userId)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		return s.deleteEmailChanges(ctx, tx, userId)
+	})
+}
+
+func (s *UserStore) deleteEmailChanges(ctx context.Context, tx *sql.Tx, userId int64) error {
+	query := `DELETE FROM email_changes WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userId)
+	return err
 }
@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newInvitedUser() (*User, *MailJob) {
+	user := &User{
+		Username: "jane",
+		Email:    "jane@example.com",
+		Role:     Role{Name: "user"},
+	}
+	mail := &MailJob{
+		Template: "user_invitation",
+		Locale:   "en",
+		Username: user.Username,
+		Email:    user.Email,
+		Data:     []byte(`{}`),
+	}
+	return user, mail
+}
+
+func TestCreateAndInvite(t *testing.T) {
+	f, db := newFakeDB(t)
+	s := &UserStore{db: db}
+
+	user, mail := newInvitedUser()
+	if err := s.CreateAndInvite(context.Background(), user, "token", time.Hour, mail); err != nil {
+		t.Fatalf("CreateAndInvite: %v", err)
+	}
+
+	for _, table := range []string{"users", "user_invitations", "mail_outbox"} {
+		if n := f.rows(table); n != 1 {
+			t.Errorf("%s has %d rows, want 1", table, n)
+		}
+	}
+}
+
+func TestCreateAndInviteRollsBackWhenTheEmailCantBeQueued(t *testing.T) {
+	f, db := newFakeDB(t)
+	f.failOn("INSERT INTO mail_outbox")
+	s := &UserStore{db: db}
+
+	user, mail := newInvitedUser()
+	if err := s.CreateAndInvite(context.Background(), user, "token", time.Hour, mail); err == nil {
+		t.Fatal("CreateAndInvite succeeded, want the error of the outbox")
+	}
+
+	for _, table := range []string{"users", "user_invitations", "mail_outbox"} {
+		if n := f.rows(table); n != 0 {
+			t.Errorf("%s has %d rows, want 0", table, n)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package totp implements RFC 6238 time-based one-time passwords, compatible with the usual authenticator apps
+// (HMAC-SHA1, 6 digits, 30 second steps).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Skew is how many steps before and after the current one are still accepted, to make up for clock drift
+	Skew = 1
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns the otpauth:// URI authenticator apps enrol with, usually shown as a QR code
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step is the time step t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the step t falls in
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t), Digits), nil
+}
+
+// Validate checks code against the steps around t, returning the step it matched so callers
+// can refuse to accept the same code twice.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	return ValidateAfter(secret, code, t, math.MinInt64)
+}
+
+// ValidateAfter is Validate ignoring the steps up to lastStep, whose codes were already used
+func ValidateAfter(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := max(current-Skew, lastStep+1); step <= current+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step, Digits)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// hotp is RFC 4226 with the step as the counter
+func hotp(key []byte, step int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulus := uint32(1)
+	for range digits {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%modulus)
+}
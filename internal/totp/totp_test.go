@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// rfcVectors are the SHA1 test vectors of RFC 6238, Appendix B
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range rfcVectors {
+		if got := hotp(key, Step(time.Unix(v.unix, 0)), 8); got != v.code {
+			t.Errorf("hotp at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestCodeIsTheLastDigitsOfTheVectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := Code(rfcSecret, time.Unix(v.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := v.code[len(v.code)-Digits:]; got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidateAcceptsTheSkewWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, now.Add(time.Duration(tt.offset)*Period))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %t, want %t", ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) = true, want false", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "123456", now); ok {
+		t.Error("Validate with an invalid secret = true, want false")
+	}
+}
+
+func TestValidateAfterRejectsReplays(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := ValidateAfter(rfcSecret, code, now, 0)
+	if !ok {
+		t.Fatal("first use of the code was rejected")
+	}
+
+	// the same code, within the skew window of the next step
+	if _, ok := ValidateAfter(rfcSecret, code, now.Add(Period), step); ok {
+		t.Error("code was accepted twice")
+	}
+
+	// a code of an earlier step than the one used
+	previous, err := Code(rfcSecret, now.Add(-Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateAfter(rfcSecret, previous, now, step); ok {
+		t.Error("code of an earlier step was accepted after a later one was used")
+	}
+
+	next, err := Code(rfcSecret, now.Add(Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ValidateAfter(rfcSecret, next, now.Add(Period), step); !ok || got != step+1 {
+		t.Errorf("code of the next step = (%d, %t), want (%d, true)", got, ok, step+1)
+	}
+}